@@ -0,0 +1,147 @@
+package e2e
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// Package e2e runs the tests behind the fakewiki build tag, in any package in the module.
+// Those can't run under a plain go test: ybtools needs a config.yml and botpassword in the
+// working directory as soon as it's imported, and the FRS keeps its state in package globals,
+// so only one run can happen per process. That goes for the tests of any package that imports
+// ybtools, not just those running the whole FRS against the FakeWiki fixtures. This package
+// doesn't import ybtools itself, so it can build those tests, and run each of them on its own
+// in a scratch directory with the files it needs.
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scratchFiles are the files ybtools needs in the working directory. Nothing ever connects
+// to the API endpoint, as the tests only talk to a FakeWiki.
+var scratchFiles = map[string]string{
+	"config.yml":  "apiendpoint: https://example.invalid/w/api.php\nbotusername: FRS test\n",
+	"botpassword": "unused",
+}
+
+func TestFakeWiki(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building and running the FakeWiki tests takes a while")
+	}
+
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildDir, err := ioutil.TempDir("", "frs-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	for _, pkg := range taggedPackages(t, root) {
+		pkg := pkg
+		t.Run(pkg, func(t *testing.T) {
+			runPackage(t, root, buildDir, pkg)
+		})
+	}
+}
+
+// taggedPackages takes the root of the module, and returns the import paths of the packages
+// in it that have tests behind the fakewiki build tag.
+func taggedPackages(t *testing.T, root string) (packages []string) {
+	untagged := listTestFiles(t, root)
+	for pkg, files := range listTestFiles(t, root, "-tags", "fakewiki") {
+		if files != untagged[pkg] {
+			packages = append(packages, pkg)
+		}
+	}
+	if len(packages) == 0 {
+		t.Fatal("found no packages with FakeWiki tests")
+	}
+	return
+}
+
+// listTestFiles takes the root of the module and any extra flags for go list, and returns
+// every package in the module mapped to the names of its test files.
+func listTestFiles(t *testing.T, root string, flags ...string) map[string]string {
+	args := append([]string{"list"}, flags...)
+	args = append(args, "-f", "{{.ImportPath}} {{.TestGoFiles}}{{.XTestGoFiles}}", "./...")
+	list := exec.Command("go", args...)
+	list.Dir = root
+	output, err := list.Output()
+	if err != nil {
+		t.Fatalf("failed to list the packages in the module: %v", err)
+	}
+
+	files := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if split := strings.SplitN(line, " ", 2); len(split) == 2 {
+			files[split[0]] = split[1]
+		}
+	}
+	return files
+}
+
+// runPackage takes the root of the module, the directory to build into, and the import path
+// of a package, and builds the package's FakeWiki tests and runs each of them in its own process.
+func runPackage(t *testing.T, root, buildDir, pkg string) {
+	binary := filepath.Join(buildDir, strings.NewReplacer("/", "_", ".", "_").Replace(pkg)+".test")
+	build := exec.Command("go", "test", "-c", "-tags", "fakewiki", "-o", binary, pkg)
+	build.Dir = root
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the FakeWiki tests: %v\n%s", err, output)
+	}
+
+	list := exec.Command(binary, "-test.list", ".")
+	list.Dir = newScratchDir(t, buildDir, filepath.Base(binary)+"-list")
+	output, err := list.Output()
+	if err != nil {
+		t.Fatalf("failed to list the FakeWiki tests: %v", err)
+	}
+
+	for _, name := range strings.Fields(string(output)) {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			test := exec.Command(binary, "-test.run", "^"+name+"$", "-test.v")
+			test.Dir = newScratchDir(t, buildDir, filepath.Base(binary)+"-"+name)
+			test.Env = append(os.Environ(), "FRS_FAKEWIKI="+filepath.Join(root, "testdata", "fakewiki"))
+			if output, err := test.CombinedOutput(); err != nil {
+				t.Errorf("%s failed: %v\n%s", name, err, output)
+			}
+		})
+	}
+}
+
+// newScratchDir takes the directory the tests were built into and a name, and creates
+// a directory within it holding the files ybtools needs.
+func newScratchDir(t *testing.T, buildDir, name string) string {
+	dir := filepath.Join(buildDir, name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for filename, contents := range scratchFiles {
+		if err := ioutil.WriteFile(filepath.Join(dir, filename), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,280 @@
+//go:build fakewiki
+// +build fakewiki
+
+package main
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These tests run the whole FRS against the FakeWiki fixtures in testdata/fakewiki.
+// ybtools reads its config.yml and botpassword as soon as it's imported, and the FRS
+// keeps its state in package globals, so they're behind the fakewiki build tag, and
+// each has to run in its own process from a directory holding those files. The e2e
+// package does all of that; see e2e/fakewiki_test.go.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"yapperbot-frs/src/clock"
+	"yapperbot-frs/src/state"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// testSeed is the seed every test runs with, so that they're reproducible.
+const testSeed int64 = 1
+
+// hasRun is set once a test has run the FRS in this process.
+var hasRun bool
+
+// loadFakeWiki loads the FakeWiki fixtures, and points the config at them. The fixtures are
+// read from the directory in FRS_FAKEWIKI, if it's set, or testdata/fakewiki otherwise.
+func loadFakeWiki(t *testing.T) *wiki.FakeWiki {
+	if hasRun {
+		t.Skip("the FRS can only run once per process; run each test on its own with -test.run")
+	}
+	hasRun = true
+
+	dir := os.Getenv("FRS_FAKEWIKI")
+	if dir == "" {
+		dir = filepath.Join("testdata", "fakewiki")
+	}
+	w, err := wiki.LoadFakeWiki(dir)
+	if err != nil {
+		t.Fatal("failed to load fake wiki fixtures:", err)
+	}
+
+	yapperconfig.Config.FRSPageID = "110754"
+	yapperconfig.Config.GAGuidelinesHeaderPageID = "110769"
+	yapperconfig.Config.SentCountPageID = "110772"
+	yapperconfig.Config.RFCsDonePageID = "111355"
+	// start from a cursor from before the GA nomination in the fixtures, as if we'd run before;
+	// on a first run, the bot only starts watching the category
+	state.SetCursor("Category:Good article nominees", state.Cursor{Timestamp: "2020-01-01T00:00:00Z"})
+	// there's no point pacing sends to a FakeWiki
+	yapperconfig.Config.SendRate = 6000
+	yapperconfig.Config.SendBurst = 10
+	return w
+}
+
+// editSubscription takes a FakeWiki, a subscription on the FRS list as written there,
+// and some named parameters, and adds the parameters to the subscription.
+func editSubscription(t *testing.T, w *wiki.FakeWiki, subscription, params string) {
+	page := w.Page("Wikipedia:Feedback request service")
+	if !strings.Contains(page.Content, subscription) {
+		t.Fatalf("the FRS list fixture has no %s", subscription)
+	}
+	page.Content = strings.Replace(page.Content, subscription, strings.TrimSuffix(subscription, "}}")+params+"}}", 1)
+}
+
+// assertSection takes a FakeWiki, a username, and the title and start of the text of the
+// one section we expect to have been added to their talk page, and checks it was.
+func assertSection(t *testing.T, w *wiki.FakeWiki, username, title, textPrefix string) {
+	t.Helper()
+	sections := w.Sections("User talk:" + username)
+	if len(sections) != 1 {
+		t.Fatalf("%s was sent %d sections, want 1: %+v", username, len(sections), sections)
+	}
+	if sections[0].SectionTitle != title {
+		t.Errorf("%s was sent a section titled %q, want %q", username, sections[0].SectionTitle, title)
+	}
+	if !strings.HasPrefix(sections[0].Text, textPrefix) {
+		t.Errorf("%s was sent a section reading %q, want it to start %q", username, sections[0].Text, textPrefix)
+	}
+	if !strings.HasSuffix(sections[0].Text, "}} ~~~~") {
+		t.Errorf("%s was sent a section reading %q, want it signed after the template", username, sections[0].Text)
+	}
+}
+
+// assertNoSection takes a FakeWiki and a username, and checks nothing was added to their talk page.
+func assertNoSection(t *testing.T, w *wiki.FakeWiki, username string) {
+	t.Helper()
+	if sections := w.Sections("User talk:" + username); len(sections) != 0 {
+		t.Errorf("%s was sent %d sections, want none: %+v", username, len(sections), sections)
+	}
+}
+
+// biographyRfCText returns how a section about the RfC in the fixtures starts, for a subscription to the given header.
+func biographyRfCText(header string) string {
+	return "{{subst:FRS notification|title0=Talk:Example biography|header0=" + header + "|type0=request for comment|rfcid0=ABCDEF1|question0="
+}
+
+// riverGANText is how a section about the GA nomination in the fixtures starts.
+const riverGANText = "{{subst:FRS notification|title0=Talk:Example river|header0=Geography and places|type0=Good Article nomination"
+
+func TestRunSendsSections(t *testing.T) {
+	w := loadFakeWiki(t)
+	run(w, testSeed)
+
+	assertSection(t, w, "Example generalist", "Feedback request: All RfCs request for comment", biographyRfCText("All RfCs"))
+	assertSection(t, w, "Example biographer", "Feedback request: Biographies request for comment", biographyRfCText("Biographies"))
+	assertSection(t, w, "Example unlimited", "Feedback request: Biographies request for comment", biographyRfCText("Biographies"))
+	assertSection(t, w, "Example geographer", "Feedback request: Geography and places Good Article nomination", riverGANText)
+
+	if done := w.Page("User:Yapperbot/FRS/RfCsDone.json").Content; !strings.Contains(done, "ABCDEF1") {
+		t.Errorf("the RfC wasn't marked done: %s", done)
+	}
+}
+
+func TestRunSkipsIneligibleUsers(t *testing.T) {
+	w := loadFakeWiki(t)
+	w.Users["Example biographer"] = wiki.FakeUser{Blocked: true}
+	w.Users["Example geographer"] = wiki.FakeUser{Missing: true}
+	run(w, testSeed)
+
+	assertNoSection(t, w, "Example biographer")
+	assertNoSection(t, w, "Example geographer")
+	assertSection(t, w, "Example unlimited", "Feedback request: Biographies request for comment", biographyRfCText("Biographies"))
+}
+
+func TestRunRetriesProtectedTalkPages(t *testing.T) {
+	w := loadFakeWiki(t)
+	w.AddPage(wiki.FakePage{Title: "User talk:Example unlimited", Protected: true})
+	run(w, testSeed)
+
+	assertNoSection(t, w, "Example unlimited")
+	assertSection(t, w, "Example biographer", "Feedback request: Biographies request for comment", biographyRfCText("Biographies"))
+
+	var queue []struct {
+		Username string `json:"username"`
+		Title    string `json:"title"`
+		Attempts int    `json:"attempts"`
+	}
+	state.Get("retryqueue", &queue)
+	if len(queue) != 1 || queue[0].Username != "Example unlimited" || queue[0].Title != "Talk:Example biography" || queue[0].Attempts != 1 {
+		t.Errorf("got retry queue %+v, want the message to Example unlimited after 1 attempt", queue)
+	}
+}
+
+func TestRunFallsBackFromEmail(t *testing.T) {
+	w := loadFakeWiki(t)
+	editSubscription(t, w, "{{frs user|Example geographer}}", "|delivery=email")
+	editSubscription(t, w, "{{frs user|Example unlimited|0}}", "|delivery=email")
+	w.Users["Example unlimited"] = wiki.FakeUser{NoEmail: true}
+	run(w, testSeed)
+
+	if len(w.Emails) != 1 || w.Emails[0].To != "Example geographer" {
+		t.Fatalf("got emails %+v, want one to Example geographer", w.Emails)
+	}
+	if subject := w.Emails[0].Subject; subject != "Feedback request: Geography and places Good Article nomination" {
+		t.Errorf("got email subject %q", subject)
+	}
+	if !strings.Contains(w.Emails[0].Text, "Talk:Example river") {
+		t.Errorf("the email doesn't mention the nomination: %q", w.Emails[0].Text)
+	}
+	assertNoSection(t, w, "Example geographer")
+	assertSection(t, w, "Example unlimited", "Feedback request: Biographies request for comment", biographyRfCText("Biographies"))
+}
+
+// geographerDigest is a message to Example geographer about the GA nomination in the fixtures, as it's
+// kept in the state store while being held for their daily digest.
+type geographerDigest struct {
+	Username string    `json:"username"`
+	Header   string    `json:"header"`
+	Type     string    `json:"type"`
+	Title    string    `json:"title"`
+	Digest   string    `json:"digest"`
+	Held     time.Time `json:"held"`
+}
+
+// holdRiverGANForDigest switches Example geographer to a daily digest, and holds a message about the
+// GA nomination in the fixtures for it from two days ago, so that it's due.
+func holdRiverGANForDigest(t *testing.T, w *wiki.FakeWiki) geographerDigest {
+	editSubscription(t, w, "{{frs user|Example geographer}}", "|digest=daily")
+	held := geographerDigest{
+		Username: "Example geographer",
+		Header:   "<!--gan-->Geography and places",
+		Type:     "Good Article nomination",
+		Title:    "Talk:Example river",
+		Digest:   "daily",
+		Held:     clock.Now().Add(-48 * time.Hour).UTC().Truncate(time.Second),
+	}
+	state.Set("digests", []geographerDigest{held})
+	return held
+}
+
+func TestRunSendsDueDigests(t *testing.T) {
+	w := loadFakeWiki(t)
+	holdRiverGANForDigest(t, w)
+	run(w, testSeed)
+
+	assertSection(t, w, "Example geographer", "Feedback request: Geography and places Good Article nomination", riverGANText)
+
+	var digests []geographerDigest
+	state.Get("digests", &digests)
+	for _, held := range digests {
+		if clock.Since(held.Held) > time.Hour {
+			t.Errorf("the message held %v ago is still being held: %+v", clock.Since(held.Held), held)
+		}
+	}
+}
+
+func TestRunHoldsDigestsOverLimit(t *testing.T) {
+	w := loadFakeWiki(t)
+	held := holdRiverGANForDigest(t, w)
+	// Example geographer has the default limit of 1, and has already had a message this month
+	w.Page("User:Yapperbot/FRS/SentCount.json").Content = `{"month":"` + clock.Now().Format("2006-01") +
+		`","headers":{"<!--gan-->Geography and places":{"Example geographer":1}}}`
+	run(w, testSeed)
+
+	assertNoSection(t, w, "Example geographer")
+
+	var digests []geographerDigest
+	state.Get("digests", &digests)
+	if len(digests) != 1 || digests[0] != held {
+		t.Errorf("got held messages %+v, want only %+v", digests, held)
+	}
+}
+
+func TestRunHoldsDigestsForIneligibleUsers(t *testing.T) {
+	w := loadFakeWiki(t)
+	held := holdRiverGANForDigest(t, w)
+	w.Users["Example geographer"] = wiki.FakeUser{Blocked: true}
+	run(w, testSeed)
+
+	assertNoSection(t, w, "Example geographer")
+
+	var digests []geographerDigest
+	state.Get("digests", &digests)
+	if len(digests) != 1 || digests[0] != held {
+		t.Errorf("got held messages %+v, want only %+v", digests, held)
+	}
+}
+
+func TestRunCountsHeldDigestMessagesWhenSelecting(t *testing.T) {
+	w := loadFakeWiki(t)
+	// Example geographer has the default limit of 1, and two nominations come in that they could be picked for
+	editSubscription(t, w, "{{frs user|Example geographer}}", "|digest=daily")
+	w.AddPage(wiki.FakePage{
+		Title:      "Talk:Example mountain",
+		Content:    "{{GA nominee|12:00, 2 June 2020 (UTC)|nominator=[[User:Example|Example]]|page=1|subtopic=Geography|status=|note=}}",
+		Categories: map[string]string{"Category:Good article nominees": "2020-06-02T12:00:00Z"},
+	})
+	run(w, testSeed)
+
+	assertNoSection(t, w, "Example geographer")
+
+	var digests []geographerDigest
+	state.Get("digests", &digests)
+	if len(digests) != 1 || digests[0].Username != "Example geographer" {
+		t.Errorf("got held messages %+v, want only one for Example geographer", digests)
+	}
+}
@@ -20,23 +20,24 @@ package main
 
 import (
 	"log"
-	"math/rand"
 	"yapperbot-frs/src/frslist"
 	"yapperbot-frs/src/messages"
+	"yapperbot-frs/src/random"
+	"yapperbot-frs/src/report"
 	"yapperbot-frs/src/rfc"
-
-	"cgt.name/pkg/go-mwclient"
+	"yapperbot-frs/src/wiki"
 )
 
 const maxMsgsToSend int = 15
 const minMsgsToSend int = 5
 
-// requestFeedbackFor takes an object that implements frsRequesting and a mwclient instance,
-// and processes the feedback request for the frsRequesting object.
-func requestFeedbackFor(requester frsRequesting, w *mwclient.Client) {
+// requestFeedbackFor takes an object that implements frsRequesting and a Wiki,
+// and processes the feedback request for the frsRequesting object. It returns the number
+// of users it wanted to message about the request, and the number it actually queued messages for.
+func requestFeedbackFor(requester frsRequesting, w wiki.Wiki) (wanted int, selected int) {
 	// msgsToSend is a randomly-selected number of messages we want to send out.
 	// it evaluates out to any number between max and min
-	var msgsToSend int = (rand.Intn(maxMsgsToSend-minMsgsToSend) + minMsgsToSend)
+	var msgsToSend int = (random.Intn(maxMsgsToSend-minMsgsToSend) + minMsgsToSend)
 
 	// headersToSendTo will be our slice of headers that we want to consider users in.
 	// it's important that this is a separate array, as we later consider its length
@@ -56,23 +57,31 @@ func requestFeedbackFor(requester frsRequesting, w *mwclient.Client) {
 		}
 	}
 
-	var rfcid string
+	var rfcid, question string
 	if rfc, isRfC := requester.(rfc.RfC); isRfC {
 		rfcid = rfc.ID
+		question = rfc.Question
 	}
 
 	if len(headersToSendTo) > 0 {
-		users := frslist.GetUsersFromHeaders(headersToSendTo, allHeader, msgsToSend)
+		users := frslist.GetUsersFromHeaders(headersToSendTo, allHeader, requester.RequestType(), msgsToSend)
+		selectedUsers := make([]report.SelectedUser, 0, len(users))
 		for _, user := range users {
 			messages.QueueMessage(&messages.Message{
-				User:  user,
-				Type:  requester.RequestType(),
-				Title: requester.PageTitle(),
-				RFCID: rfcid,
+				User:     user,
+				Type:     requester.RequestType(),
+				Title:    requester.PageTitle(),
+				RFCID:    rfcid,
+				Question: question,
 			})
 			log.Println("Queued a message for", user.Username, "to give feedback on", requester.PageTitle(), "in", user.Header)
+			selectedUsers = append(selectedUsers, report.SelectedUser{Username: user.Username, Header: user.Header})
 		}
+		report.UsersSelected(requester.PageTitle(), requester.RequestType(), selectedUsers)
+		selected = len(users)
 	} else {
 		log.Println("WARNING: Headers to send to returned as less than one for page", requester.PageTitle(), "so ignoring for now, but this could be a bug")
+		report.NoMatchingHeaders(requester.PageTitle(), requester.RequestType())
 	}
+	return msgsToSend, selected
 }
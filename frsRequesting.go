@@ -19,7 +19,7 @@ package main
 //
 
 // frsRequesting is an interface covering all objects that could request FRS.
-// At the moment, that's only ga.Nom and rfc.RfC
+// At the moment, that's ga.Nom, fac.Nom, peerreview.Nom, requestedmove.Move and rfc.RfC
 type frsRequesting interface {
 	// IncludeHeader returns a bool indicating if the header is applicable for the
 	// requesting instance, and also a bool indicating if the header is the catch-all
@@ -21,43 +21,27 @@ package main
 import (
 	"regexp"
 	"strings"
+	"yapperbot-frs/src/fac"
 	"yapperbot-frs/src/ga"
+	"yapperbot-frs/src/peerreview"
+	"yapperbot-frs/src/requestedmove"
 	"yapperbot-frs/src/rfc"
+	"yapperbot-frs/src/wikitext"
 )
 
-// rfcMatcher is a regex that matches {{rfc}} templates on pages.
-// Its contents are documented in matchers.go:init().
-var rfcMatcher *regexp.Regexp
+// topicTemplates are the templates that carry an article's topic on its talk page,
+// in the order we prefer them. They're used to find the topic for FACs.
+var topicTemplates = []string{"Article history", "ArticleHistory", "GA"}
 
-// gaMatcher is a regex that matches {{GA nominee}} templates on pages.
-// Its contents are documented in matchers.go:init().
-var gaMatcher *regexp.Regexp
+// signatureEnd is what the first signature after an RfC statement ends with,
+// and so marks the end of the statement.
+const signatureEnd string = "(UTC)"
 
-// namedParamMatcher is a regex that matches against named parameters in
-// a template parameter list; e.g. {{template|name=param}}, matching name=param.
-// Its contents are documented in matchers.go:init().
-var namedParamMatcher *regexp.Regexp
-
-const rfcIDParam string = "rfcid="
+// signatureTimestampRegex matches the timestamp at the end of a signature.
+var signatureTimestampRegex *regexp.Regexp
 
 func init() {
-	// RfC matching regex.
-	// First capture group is all the params of the rfc template
-	// (all non-named params are to be treated as categories)
-	// second capture group is the actual content of the RfC
-	rfcMatcher = regexp.MustCompile(`(?i){{rfc\|(.*?)}}(.|\n)*?\(UTC\)`)
-
-	// GA nom matching regex.
-	// First capture group is the topic. Second capture group is the subtopic.
-	// IMPORTANT: If the subtopic is empty, the first capture group will be empty string, and likewise for the topic.
-	// This regex will always prefer a topic to a subtopic, but will settle for a subtopic if no topic is available.
-	// Great thanks go to Ouims from #regex on Freenode for the help with debugging and correcting this regex!
-	gaMatcher = regexp.MustCompile(`(?i){{GA nominee(?:\|(?:[^|}]*?\|)*(?:[\t\f\v ]*?(?:subtopic=([^|}]+).*?)|topic=([^|}]+))|.*?)*}}`)
-
-	// Matches against named parameters in the parameter list.
-	// Ensures the equals is after a named param specifically.
-	// The [\w\d\s] set means it won't trigger on {{=}} and the like.
-	namedParamMatcher = regexp.MustCompile(`(?m)^[\w\d\s]*?=`)
+	signatureTimestampRegex = regexp.MustCompile(`\d{1,2}:\d{2}, \d{1,2} \w+ \d{4} \(UTC\)`)
 }
 
 // extractRfcs takes a string of content containing rfcs, and the page title,
@@ -66,43 +50,130 @@ func init() {
 // extractRfcs output should be checked for RfCs with no ID string, as those haven't
 // yet been assigned an ID by Legobot.
 func extractRfcs(content string, title string, excludeDone bool) (rfcs []rfc.RfC, err error) {
-	matchedRfcTags := rfcMatcher.FindAllStringSubmatch(content, -1)
-	for _, tag := range matchedRfcTags {
-		// group 1 contains all the parameters; split on | to find the individual params
-		params := strings.Split(tag[1], "|")
-
-		rfcID, categories, feedbackDone := func(pr []string) (id string, cats map[string]bool, done bool) {
-			cats = make(map[string]bool)
-			for _, p := range pr {
-				if strings.Contains(p, rfcIDParam) {
-					id = strings.TrimPrefix(p, rfcIDParam)
-				} else if !namedParamMatcher.MatchString(p) {
-					// it's not a named param, we can assume it's a category
-					// for more on why this is like this, see frsRequesting
-					cats[p] = true
-				}
-			}
-			if id != "" && rfc.AlreadyDone(id) {
-				done = true
+	for _, tag := range wikitext.ParseTemplates(content) {
+		if !tag.Is("rfc") {
+			continue
+		}
+
+		// all non-named params are to be treated as categories
+		// for more on why this is like this, see frsRequesting
+		categories := map[string]bool{}
+		for _, p := range tag.Positional {
+			if p != "" {
+				categories[p] = true
 			}
-			return
-		}(params)
+		}
+
+		rfcID, _ := tag.Param("rfcid")
+		feedbackDone := rfcID != "" && rfc.AlreadyDone(rfcID)
 
 		if feedbackDone && excludeDone {
 			continue
 		} else {
-			rfcs = append(rfcs, rfc.RfC{ID: rfcID, Categories: categories, FeedbackDone: feedbackDone, PageHolding: title})
+			rfcs = append(rfcs, rfc.RfC{
+				ID:           rfcID,
+				Categories:   categories,
+				FeedbackDone: feedbackDone,
+				PageHolding:  title,
+				Question:     rfc.QuestionFromStatement(rfcStatement(content, tag.End)),
+			})
 		}
 	}
 	return
 }
 
+// rfcStatement takes a page's content and the offset of the end of an {{rfc}} template,
+// and returns the wikitext of the RfC statement - everything from the template up to
+// the end of the first signature. If there's no signature, it returns empty string.
+func rfcStatement(content string, templateEnd int) string {
+	statementEnd := strings.Index(content[templateEnd:], signatureEnd)
+	if statementEnd == -1 {
+		return ""
+	}
+	return content[templateEnd : templateEnd+statementEnd]
+}
+
 // extractGANom takes a page name and content that's been nominated for GA,
 // and returns the GA nom object.
 func extractGANom(content string, title string) (nom ga.Nom) {
-	matchedGaTag := gaMatcher.FindStringSubmatch(content)
-	// first capture group is name of topic, if applicable
-	// second capture group is name of subtopic
-	nom = ga.Nom{Topic: matchedGaTag[2], Subtopic: matchedGaTag[1], Article: title}
+	nom = ga.Nom{Article: title}
+	if tag, found := findTemplate(content, "GA nominee"); found {
+		// either of these may be empty; IncludeHeader prefers the topic, but will settle for the subtopic
+		nom.Topic, _ = tag.Param("topic")
+		nom.Subtopic, _ = tag.Param("subtopic")
+	}
+	return
+}
+
+// extractFACNom takes a talk page name and content for an article nominated for FA,
+// and returns the FAC nom object.
+func extractFACNom(content string, title string) (nom fac.Nom) {
+	nom = fac.Nom{Article: title}
+	for _, templateName := range topicTemplates {
+		if tag, found := findTemplate(content, templateName); found {
+			if topic, _ := tag.Param("topic"); topic != "" {
+				nom.Topic = topic
+				return
+			}
+		}
+	}
 	return
 }
+
+// extractPeerReview takes a talk page name and content for an article up for peer review,
+// and returns the peer review nom object.
+func extractPeerReview(content string, title string) (nom peerreview.Nom) {
+	nom = peerreview.Nom{Article: title}
+	if tag, found := findTemplate(content, "Peer review"); found {
+		nom.Topic, _ = tag.Param("topic")
+	}
+	return
+}
+
+// extractMoves takes a string of content containing requested moves, and the page title,
+// and returns a slice of moves. Like extractRfcs, it can optionally be passed excludeDone,
+// which prevents already-done moves from being included in the generated list.
+func extractMoves(content string, title string, excludeDone bool) (moves []requestedmove.Move) {
+	var tags []wikitext.Template
+	for _, tag := range wikitext.ParseTemplates(content) {
+		if tag.Is("Requested move/dated") {
+			tags = append(tags, tag)
+		}
+	}
+
+	for i, tag := range tags {
+		// the discussion runs up to the next one on the page, if there is one
+		discussionEnd := len(content)
+		if i+1 < len(tags) {
+			discussionEnd = tags[i+1].Start
+		}
+		moveID := requestedmove.GenerateID(title, tag.Params, moveTimestamp(content[tag.End:discussionEnd]))
+		// moves we'd already done before IDs included the timestamp are recorded under their old IDs;
+		// every open move is recorded under its new ID on each run, so this is only needed once
+		feedbackDone := requestedmove.AlreadyDone(moveID) || requestedmove.AlreadyDone(requestedmove.LegacyID(title, tag.Params))
+
+		if feedbackDone && excludeDone {
+			continue
+		}
+		moves = append(moves, requestedmove.Move{ID: moveID, FeedbackDone: feedbackDone, PageHolding: title})
+	}
+	return
+}
+
+// moveTimestamp takes the wikitext of a requested move discussion, following its {{Requested move/dated}}
+// template, and returns the timestamp of the first signature in it, which is the one opening the discussion,
+// or empty string if it hasn't got one.
+func moveTimestamp(discussion string) string {
+	return signatureTimestampRegex.FindString(discussion)
+}
+
+// findTemplate takes some content and a template name, and returns the first
+// top-level transclusion of that template in the content, if there is one.
+func findTemplate(content string, name string) (wikitext.Template, bool) {
+	for _, tag := range wikitext.ParseTemplates(content) {
+		if tag.Is(name) {
+			return tag, true
+		}
+	}
+	return wikitext.Template{}, false
+}
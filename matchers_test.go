@@ -0,0 +1,105 @@
+//go:build fakewiki
+// +build fakewiki
+
+package main
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These are behind the fakewiki build tag for the same reason as the tests in fakewiki_test.go,
+// and are run the same way, by the e2e package.
+
+import (
+	"testing"
+	"yapperbot-frs/src/requestedmove"
+)
+
+// bannerShell wraps some talk page banners in {{WikiProject banner shell}}, as most talk pages now do.
+func bannerShell(banners string) string {
+	return "{{WikiProject banner shell|class=B|1=\n" + banners + "\n{{WikiProject Music|importance=low}}\n}}\n== Discussion ==\n"
+}
+
+func TestExtractGANomInBannerShell(t *testing.T) {
+	nom := extractGANom(bannerShell("{{GA nominee|12:00, 1 June 2020 (UTC)|nominator=Someone|page=1|subtopic=Music}}"), "Talk:Example song")
+	if nom.Subtopic != "Music" {
+		t.Errorf("got subtopic %q, want Music", nom.Subtopic)
+	}
+}
+
+func TestExtractPeerReviewInBannerShell(t *testing.T) {
+	nom := extractPeerReview(bannerShell("{{Peer review|topic=music}}"), "Talk:Example song")
+	if nom.Topic != "music" {
+		t.Errorf("got topic %q, want music", nom.Topic)
+	}
+}
+
+func TestExtractRfcsInBannerShell(t *testing.T) {
+	rfcs, err := extractRfcs(bannerShell("{{rfc|bio|rfcid=ABCDEF1}}"), "Talk:Example song", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rfcs) != 1 || rfcs[0].ID != "ABCDEF1" || !rfcs[0].Categories["bio"] {
+		t.Errorf("got %+v, want the one RfC in the banner shell", rfcs)
+	}
+}
+
+func TestExtractMovesInBannerShell(t *testing.T) {
+	moves := extractMoves(bannerShell("{{Requested move/dated|Example song (single)}}"), "Talk:Example song", false)
+	if len(moves) != 1 || moves[0].PageHolding != "Talk:Example song" {
+		t.Errorf("got %+v, want the one move in the banner shell", moves)
+	}
+}
+
+// moveDiscussion returns the wikitext of a requested move discussion for the page, opened at the given time.
+func moveDiscussion(opened string) string {
+	return "== Requested move ==\n{{Requested move/dated|Example song (single)}}\n\n" +
+		"[[:Example song]] → {{no redirect|Example song (single)}} – It's a single. [[User:Example|Example]] ([[User talk:Example|talk]]) " + opened + "\n"
+}
+
+func TestExtractMovesIDs(t *testing.T) {
+	first := extractMoves(moveDiscussion("12:00, 1 June 2020 (UTC)"), "Talk:Example song", false)
+	relisted := extractMoves(moveDiscussion("12:00, 1 June 2020 (UTC)")+
+		"<small>—&nbsp;''Relisting.''</small> [[User:Relister|Relister]] ([[User talk:Relister|talk]]) 12:00, 8 June 2020 (UTC)\n", "Talk:Example song", false)
+	later := extractMoves(moveDiscussion("12:00, 1 August 2020 (UTC)"), "Talk:Example song", false)
+	if len(first) != 1 || len(relisted) != 1 || len(later) != 1 {
+		t.Fatalf("got moves %+v, %+v and %+v, want one each", first, relisted, later)
+	}
+
+	if first[0].ID != relisted[0].ID {
+		t.Errorf("got ID %s after relisting, want it unchanged from %s", relisted[0].ID, first[0].ID)
+	}
+	if first[0].ID == later[0].ID {
+		t.Errorf("got the same ID %s for the same move proposed again later, want a new one", later[0].ID)
+	}
+}
+
+func TestExtractMovesSeparatesDiscussions(t *testing.T) {
+	moves := extractMoves(moveDiscussion("12:00, 1 June 2020 (UTC)")+"{{Requested move/dated|Example song (single)}}\nNo signature yet\n", "Talk:Example song", false)
+	if len(moves) != 2 || moves[0].ID == moves[1].ID {
+		t.Errorf("got moves %+v, want two with different IDs, the second not taking the first's timestamp", moves)
+	}
+}
+
+func TestExtractMovesRecognisesLegacyIDs(t *testing.T) {
+	requestedmove.MarkMovesDone([]requestedmove.Move{{ID: requestedmove.LegacyID("Talk:Example song", []string{"Example song (single)"})}})
+
+	moves := extractMoves(moveDiscussion("12:00, 1 June 2020 (UTC)"), "Talk:Example song", false)
+	if len(moves) != 1 || !moves[0].FeedbackDone {
+		t.Errorf("got moves %+v, want the move done before its ID included the timestamp still counted as done", moves)
+	}
+}
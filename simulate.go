@@ -0,0 +1,364 @@
+package main
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"yapperbot-frs/src/clock"
+	"yapperbot-frs/src/fac"
+	"yapperbot-frs/src/frslist"
+	"yapperbot-frs/src/ga"
+	"yapperbot-frs/src/messages"
+	"yapperbot-frs/src/peerreview"
+	"yapperbot-frs/src/random"
+	"yapperbot-frs/src/requestedmove"
+	"yapperbot-frs/src/rfc"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+
+	"github.com/mashedkeyboard/ybtools/v2"
+)
+
+// defaultSimulationSeed is the seed used for simulations if no -seed is given, so that running
+// the same simulation twice gives the same results, and changes can be compared fairly.
+const defaultSimulationSeed int64 = 1
+
+// A simulatedRequest is a single entry in a simulation stream. Type is one of
+// "rfc", "ga", "fac", "peerreview" or "rm"; the other fields are used as each type needs them,
+// apart from Time, which is optional, and is when the request came in, in RFC3339 form.
+type simulatedRequest struct {
+	Type       string   `json:"type"`
+	Title      string   `json:"title"`
+	Categories []string `json:"categories"`
+	Topic      string   `json:"topic"`
+	Subtopic   string   `json:"subtopic"`
+	Time       string   `json:"time"`
+}
+
+// A timedRequester is a request from a simulation stream, along with when it came in.
+type timedRequester struct {
+	requester frsRequesting
+	at        time.Time
+}
+
+// toRequester turns a simulatedRequest into the frsRequesting it stands for.
+func (s simulatedRequest) toRequester() (frsRequesting, error) {
+	switch s.Type {
+	case "rfc":
+		categories := map[string]bool{}
+		for _, category := range s.Categories {
+			categories[category] = true
+		}
+		return rfc.RfC{ID: s.Title, Categories: categories, PageHolding: s.Title}, nil
+	case "ga":
+		return ga.Nom{Article: s.Title, Topic: s.Topic, Subtopic: s.Subtopic}, nil
+	case "fac":
+		return fac.Nom{Article: s.Title, Topic: s.Topic}, nil
+	case "peerreview":
+		return peerreview.Nom{Article: s.Title, Topic: s.Topic}, nil
+	case "rm":
+		return requestedmove.Move{ID: s.Title, PageHolding: s.Title}, nil
+	}
+	return nil, fmt.Errorf("unknown request type %q for %s", s.Type, s.Title)
+}
+
+// runSimulation takes the path to a JSON file holding a stream of requests, in the form
+// {"requests": [{"type": "rfc", "title": "Talk:Example", "categories": ["bio"]}, ...]},
+// the path to a saved copy of the FRS list wikitext, and optionally the path to a saved copy
+// of the GA topics wikitext, along with the seed to use and the number of runs to make. Each run puts
+// every request in the stream through requestFeedbackFor in the order they came in, with the clock set
+// to the time each came in (see simulatedTimes), against an in-memory wiki with nobody yet messaged.
+// Monthly limits are counted as though the stream were all one month. Runs after the first use the seeds following on
+// from the one given, so that the results show how the messages are spread out on average, not just
+// how one set of random picks happened to go. The results of all the runs are printed together.
+// Nothing is ever sent.
+func runSimulation(streamPath, listPath, gaTopicsPath string, seed int64, runs int) {
+	if runs < 1 {
+		ybtools.PanicErr("The number of simulation runs must be at least 1, not ", runs)
+	}
+
+	streamJSON, err := ioutil.ReadFile(streamPath)
+	if err != nil {
+		ybtools.PanicErr("Failed to read simulation stream with error ", err)
+	}
+	var stream struct {
+		Requests []simulatedRequest `json:"requests"`
+	}
+	if err := json.Unmarshal(streamJSON, &stream); err != nil {
+		ybtools.PanicErr("Failed to parse simulation stream with error ", err)
+	}
+
+	times := simulatedTimes(stream.Requests)
+	requesters := make([]timedRequester, 0, len(stream.Requests))
+	for i, entry := range stream.Requests {
+		requester, err := entry.toRequester()
+		if err != nil {
+			ybtools.PanicErr("Invalid request in simulation stream: ", err)
+		}
+		requesters = append(requesters, timedRequester{requester: requester, at: times[i]})
+	}
+	sort.SliceStable(requesters, func(i, j int) bool {
+		return requesters[i].at.Before(requesters[j].at)
+	})
+
+	w := simulatedWiki(listPath, gaTopicsPath)
+	if gaTopicsPath != "" {
+		ga.FetchGATopics(w)
+	}
+
+	results := newSimulationResults()
+	for run := 0; run < runs; run++ {
+		random.Seed(seed + int64(run))
+		// start every run from an untouched list, with nothing sent
+		if len(requesters) > 0 {
+			clock.SetFixed(requesters[0].at)
+		}
+		frslist.Populate(w)
+		messages.DiscardMessages()
+
+		for _, timed := range requesters {
+			clock.SetFixed(timed.at)
+			wanted, selected := requestFeedbackFor(timed.requester, w)
+			if selected == 0 {
+				results.unmatched++
+			}
+			if selected < wanted {
+				results.underServed++
+			}
+		}
+		results.addRun()
+	}
+
+	results.print(len(stream.Requests), seed)
+}
+
+// simulatedTimes takes the requests in a simulation stream, and returns when each of them came in.
+// Requests without a time are spread evenly across a month, in the order they're listed, so that the
+// weekly and daily caps, any rolling window and anything else that depends on the time applies as it
+// would to a real month of requests. That's the month of the first request with a time, or if none of
+// them have one, the current month.
+func simulatedTimes(requests []simulatedRequest) []time.Time {
+	times := make([]time.Time, len(requests))
+	month := clock.Now().UTC()
+	var monthSet bool
+	for i, request := range requests {
+		if request.Time == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, request.Time)
+		if err != nil {
+			ybtools.PanicErr("Invalid time in simulation stream for ", request.Title, ": ", err)
+		}
+		times[i] = parsed
+		if !monthSet {
+			month = parsed.UTC()
+			monthSet = true
+		}
+	}
+
+	monthStart := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	monthLength := monthStart.AddDate(0, 1, 0).Sub(monthStart)
+	for i, request := range requests {
+		if request.Time == "" {
+			times[i] = monthStart.Add(monthLength * time.Duration(i) / time.Duration(len(requests)))
+		}
+	}
+	return times
+}
+
+// simulationResults collects the results of each run of a simulation, so that they can be printed together.
+type simulationResults struct {
+	runs                   int
+	underServed, unmatched int
+	// perUser maps usernames to the number of messages they were sent in each run, in run order
+	perUser map[string][]int
+	// perHeader and atLimit map headers to the total number of messages sent for them, and the total
+	// number of their subscribers who ended up at a limit, across every run
+	perHeader map[string]int
+	atLimit   map[string]int
+	// subscribers maps headers to how many users are subscribed to them
+	subscribers map[string]int
+	// runsAtLimit maps usernames to the number of runs in which they hit a limit
+	runsAtLimit map[string]int
+}
+
+func newSimulationResults() *simulationResults {
+	return &simulationResults{
+		perUser:     map[string][]int{},
+		perHeader:   map[string]int{},
+		atLimit:     map[string]int{},
+		subscribers: map[string]int{},
+		runsAtLimit: map[string]int{},
+	}
+}
+
+// addRun adds the messages queued and held in the run just finished to the results.
+func (r *simulationResults) addRun() {
+	perUser := map[string]int{}
+	for username, queued := range messages.QueuedMessages() {
+		perUser[username] = len(queued)
+		for _, message := range queued {
+			r.perHeader[message.User.Header]++
+		}
+	}
+	// messages held for digests would be sent eventually, so they count too
+	for _, message := range messages.HeldMessages() {
+		perUser[message.User.Username]++
+		r.perHeader[message.User.Header]++
+	}
+
+	hitLimit := map[string]bool{}
+	for _, header := range frslist.GetListHeaders() {
+		users := frslist.GetUsersInHeader(header)
+		r.subscribers[header] = len(users)
+		for _, user := range users {
+			if _, seen := r.perUser[user.Username]; !seen {
+				// users who were never sent anything still need a count for each run
+				r.perUser[user.Username] = make([]int, r.runs)
+			}
+			// held messages are counted as sent above, so they count towards the limits too
+			if user.ExceedsLimitWithHeld() {
+				r.atLimit[header]++
+				hitLimit[user.Username] = true
+			}
+		}
+	}
+
+	for username, counts := range r.perUser {
+		r.perUser[username] = append(counts, perUser[username])
+	}
+	for username := range hitLimit {
+		r.runsAtLimit[username]++
+	}
+	r.runs++
+}
+
+// mean formats the average of total over the runs, leaving it as a whole number if there was only one run.
+func (r *simulationResults) mean(total int) string {
+	if r.runs == 1 {
+		return strconv.Itoa(total)
+	}
+	return strconv.FormatFloat(float64(total)/float64(r.runs), 'f', 1, 64)
+}
+
+// print prints the distribution of the messages across users and headers, along with the number of
+// requests that didn't get as many users as they wanted. Where there was more than one run, the numbers
+// are averages per run, and each user's fewest and most messages in any run are shown too.
+func (r *simulationResults) print(requests int, seed int64) {
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	if r.runs == 1 {
+		fmt.Fprintf(out, "Simulated %d requests with seed %d\n", requests, seed)
+	} else {
+		fmt.Fprintf(out, "Simulated %d requests %d times with seeds %d to %d; numbers are averages per run\n",
+			requests, r.runs, seed, seed+int64(r.runs)-1)
+	}
+	fmt.Fprintf(out, "Requests under-served: %s (of which %s reached nobody)\n\n", r.mean(r.underServed), r.mean(r.unmatched))
+
+	fmt.Fprintln(out, "Header\tSubscribers\tMessages\tAt limit")
+	headers := append([]string{}, frslist.GetListHeaders()...)
+	sort.Strings(headers)
+	for _, header := range headers {
+		fmt.Fprintf(out, "%s\t%d\t%s\t%s\n", header, r.subscribers[header], r.mean(r.perHeader[header]), r.mean(r.atLimit[header]))
+	}
+
+	fmt.Fprintf(out, "\nUsers who hit a limit: %d of %d", len(r.runsAtLimit), len(r.perUser))
+	if r.runs > 1 {
+		fmt.Fprint(out, " in at least one run")
+	}
+	fmt.Fprint(out, "\n\n")
+
+	totals := map[string]int{}
+	usernames := make([]string, 0, len(r.perUser))
+	for username, counts := range r.perUser {
+		for _, count := range counts {
+			totals[username] += count
+		}
+		usernames = append(usernames, username)
+	}
+	sort.Slice(usernames, func(i, j int) bool {
+		if totals[usernames[i]] != totals[usernames[j]] {
+			return totals[usernames[i]] > totals[usernames[j]]
+		}
+		return usernames[i] < usernames[j]
+	})
+
+	if r.runs == 1 {
+		fmt.Fprintln(out, "User\tMessages\tHit limit")
+	} else {
+		fmt.Fprintln(out, "User\tMessages\tFewest\tMost\tRuns at limit")
+	}
+	for _, username := range usernames {
+		if r.runs == 1 {
+			var hitLimit string
+			if r.runsAtLimit[username] > 0 {
+				hitLimit = "yes"
+			}
+			fmt.Fprintf(out, "%s\t%d\t%s\n", username, totals[username], hitLimit)
+			continue
+		}
+		counts := append([]int{}, r.perUser[username]...)
+		sort.Ints(counts)
+		fmt.Fprintf(out, "%s\t%s\t%d\t%d\t%d\n", username, r.mean(totals[username]), counts[0], counts[len(counts)-1], r.runsAtLimit[username])
+	}
+
+	if len(usernames) > 0 {
+		fmt.Fprintf(out, "\nMessages per user: min %s, median %s, max %s\n", r.mean(totals[usernames[len(usernames)-1]]),
+			r.mean(totals[usernames[len(usernames)/2]]), r.mean(totals[usernames[0]]))
+	}
+	out.Flush()
+}
+
+// simulatedWiki creates a FakeWiki holding the FRS list and GA topics from the given files,
+// along with an empty sentcount page. Any page IDs missing from the config are filled in.
+func simulatedWiki(listPath, gaTopicsPath string) *wiki.FakeWiki {
+	if listPath == "" {
+		ybtools.PanicErr("A saved FRS list must be given to simulate with")
+	}
+
+	w := wiki.NewFakeWiki()
+	for _, page := range []struct {
+		id   *string
+		path string
+	}{
+		{&yapperconfig.Config.FRSPageID, listPath},
+		{&yapperconfig.Config.SentCountPageID, ""},
+		{&yapperconfig.Config.GAGuidelinesHeaderPageID, gaTopicsPath},
+	} {
+		content := "{}"
+		if page.path != "" {
+			contentBytes, err := ioutil.ReadFile(page.path)
+			if err != nil {
+				ybtools.PanicErr("Failed to read ", page.path, " for simulation with error ", err)
+			}
+			content = string(contentBytes)
+		}
+
+		// if the config has no ID for the page, AddPage assigns one, so point the config at that
+		*page.id = w.AddPage(wiki.FakePage{ID: *page.id, Content: content}).ID
+	}
+	return w
+}
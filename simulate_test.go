@@ -0,0 +1,50 @@
+//go:build fakewiki
+// +build fakewiki
+
+package main
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These are behind the fakewiki build tag for the same reason as the tests in fakewiki_test.go,
+// and are run the same way, by the e2e package.
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulatedTimes(t *testing.T) {
+	requests := []simulatedRequest{
+		{Title: "Talk:First"},
+		{Title: "Talk:Second", Time: "2020-06-20T12:00:00Z"},
+		{Title: "Talk:Third"},
+	}
+	want := []string{
+		// June has 30 days, so the requests without times are ten days apart
+		"2020-06-01T00:00:00Z",
+		"2020-06-20T12:00:00Z",
+		"2020-06-21T00:00:00Z",
+	}
+
+	for i, at := range simulatedTimes(requests) {
+		if got := at.Format(time.RFC3339); got != want[i] {
+			t.Errorf("got %s for %s, want %s", got, requests[i].Title, want[i])
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"log"
+	"time"
+	"yapperbot-frs/src/clock"
+	"yapperbot-frs/src/report"
+	"yapperbot-frs/src/state"
+	"yapperbot-frs/src/wiki"
+
+	"github.com/mashedkeyboard/ybtools/v2"
+)
+
+// categoryBeginning is a timestamp from before any page could have been categorised,
+// used to get every page in a category.
+const categoryBeginning string = "2001-01-01T00:00:00Z"
+
+// categorySource is a requestSource for review processes where each page can only
+// have one request open at a time, and pages are added to a category when a request
+// is opened - such as GA nominations. It uses a cursor in the state store to track its
+// progress through the category, so that we never send messages about the same page twice.
+type categorySource struct {
+	category string
+	// load, if set, is run when the source is loaded, to set up anything extract needs.
+	load func(w wiki.Wiki)
+	// extract turns a page from the category into the request for it.
+	extract func(page wiki.Page) frsRequesting
+	// known returns whether requests from the category could ever match a FRS header.
+	known func(header string) bool
+	// requestType is the type of the requests that extract returns.
+	requestType string
+
+	// startStamp and startID are the timestamp and page ID of the latest page
+	// processed last time, loaded from our cursor.
+	startStamp, startID string
+	newCursor           bool
+	// firstItem is what we'll store as our cursor for next time once we're done.
+	firstItem *state.Cursor
+	// open is the set of titles of the requests from every page in the category at the moment.
+	// It's only fetched if there's a message to retry that needs it, and then only once.
+	open map[string]bool
+}
+
+// Name returns the name of the source for logs.
+func (s *categorySource) Name() string {
+	return s.category
+}
+
+// KnownHeader returns whether requests from the category could ever match a FRS header.
+func (s *categorySource) KnownHeader(header string) bool {
+	return s.known(header)
+}
+
+// RequestType returns the type of the requests from the category.
+func (s *categorySource) RequestType() string {
+	return s.requestType
+}
+
+// Load loads our progress through the category from our cursor.
+func (s *categorySource) Load(w wiki.Wiki) {
+	if s.load != nil {
+		s.load(w)
+	}
+
+	cursor, _ := state.GetCursor(s.category)
+	s.startStamp, s.startID = cursor.Timestamp, cursor.PageID
+	if s.startStamp == "" {
+		s.startStamp = clock.Now().Format(time.RFC3339)
+		// Set our cursor to store this now, as there's potentially going to be nothing in the queue
+		s.newCursor = true
+	}
+}
+
+// Process iterates through the pages in the category categorised since the last run,
+// and requests feedback for each of them.
+func (s *categorySource) Process(w wiki.Wiki, request func(frsRequesting)) {
+	// give it at least an hour of tranquility before invites go out
+	pages, err := w.CategoryMembers(s.category, clock.Now().Add(-time.Hour).Format(time.RFC3339), s.startStamp)
+	if err != nil {
+		ybtools.PanicErr("Errored while querying for relevant new pages with error: ", err)
+	}
+	report.PagesScanned(s.category, len(pages))
+
+	// save the timestamp and the page id of the first (latest) item into firstItem to store as our cursor later
+	if len(pages) > 0 {
+		s.firstItem = &state.Cursor{Timestamp: pages[0].CategorisedAt, PageID: pages[0].ID}
+	} else if s.newCursor {
+		// if it's a new cursor and no pages are picked up, just create it so future runs will know where to start from
+		log.Println("No pages found, and a new cursor, so creating cursor with current time for", s.category)
+		s.firstItem = &state.Cursor{Timestamp: s.startStamp}
+	}
+
+	for _, page := range pages {
+		// Because each page can only have one request open at a time, it's not necessary to do the full gamut of RfC checks here;
+		// we can instead just pass it on after checking that it's not the same page we did first last time.
+		// to do that check, we check whether the page ID and timestamp are the same (both stored in the cursor) - if they are, it's the same page
+		if (page.ID == s.startID) && (page.CategorisedAt == s.startStamp) {
+			// it's the first page from last time, we're probably at the end - skip over it
+			continue
+		}
+		request(s.extract(page))
+	}
+}
+
+// Save stores the done timestamp and page id as our cursor for next use,
+// if there's actually something to store. The state store itself is saved in finishRun.
+func (s *categorySource) Save(w wiki.Wiki) {
+	if s.firstItem != nil {
+		state.SetCursor(s.category, *s.firstItem)
+	}
+}
+
+// StillOpen returns whether the request with the given title is still open; that is,
+// whether its page is still in the category.
+func (s *categorySource) StillOpen(w wiki.Wiki, title, rfcID string) bool {
+	if s.open == nil {
+		pages, err := w.CategoryMembers(s.category, clock.Now().Format(time.RFC3339), categoryBeginning)
+		if err != nil {
+			ybtools.PanicErr("Errored while querying for open requests in ", s.category, " with error: ", err)
+		}
+
+		s.open = map[string]bool{}
+		for _, page := range pages {
+			s.open[s.extract(page).PageTitle()] = true
+		}
+	}
+	return s.open[title]
+}
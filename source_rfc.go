@@ -0,0 +1,118 @@
+package main
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"log"
+	"yapperbot-frs/src/report"
+	"yapperbot-frs/src/rfc"
+	"yapperbot-frs/src/wiki"
+
+	"github.com/mashedkeyboard/ybtools/v2"
+)
+
+// rfcSource is the requestSource for RfCs. It finds every page transcluding {{rfc}},
+// and uses the on-wiki list of completed RfC IDs to work out which ones are new.
+type rfcSource struct {
+	// open is the set of IDs of the RfCs that are open at the moment. It's only
+	// fetched if there's a message to retry that needs it, and then only once.
+	open map[string]bool
+}
+
+// Name returns the name of the source for logs.
+func (s *rfcSource) Name() string {
+	return "Category:Wikipedia requests for comment"
+}
+
+// KnownHeader returns whether a FRS header is for RfCs.
+func (s *rfcSource) KnownHeader(header string) bool {
+	return rfc.KnownHeader(header)
+}
+
+// RequestType returns the type of the requests from the source.
+func (s *rfcSource) RequestType() string {
+	return rfc.RfC{}.RequestType()
+}
+
+// Load loads the list of RfCs that have already been done.
+func (s *rfcSource) Load(w wiki.Wiki) {
+	rfc.LoadRfcsDone(w)
+}
+
+// Process gets a list of all active RfCs, and requests feedback for the ones we've not done yet.
+func (s *rfcSource) Process(w wiki.Wiki, request func(frsRequesting)) {
+	pages, err := w.EmbeddedIn("Template:Rfc")
+	if err != nil {
+		ybtools.PanicErr("Errored while querying for relevant new pages with error: ", err)
+	}
+	report.PagesScanned(s.Name(), len(pages))
+
+	for _, page := range pages {
+		// (content, title, excludeDone)
+		rfcsToProcess, err := extractRfcs(page.Content, page.Title, false)
+		if err != nil {
+			ybtools.PanicErr("extractRfcs errored with ", err)
+		}
+		rfcsDone := make([]rfc.RfC, 0, len(rfcsToProcess))
+
+	RFCLOOP:
+		for _, rfc := range rfcsToProcess {
+			if rfc.ID == "" {
+				log.Println("RfC has no ID yet on page", page.Title, "so skipping that RfC")
+				report.RfCSkippedNoID(page.Title)
+				continue RFCLOOP
+			} else if rfc.FeedbackDone {
+				log.Println("RfC feedback already done for an RfC on", page.Title, "so skipping that RfC")
+			} else {
+				log.Println("Requesting feedback for an RfC on", page.Title)
+				request(rfc)
+			}
+			rfcsDone = append(rfcsDone, rfc)
+		}
+		if len(rfcsDone) > 0 {
+			rfc.MarkRfcsDone(rfcsDone)
+		}
+	}
+}
+
+// Save saves the list of completed RfCs on-wiki.
+func (s *rfcSource) Save(w wiki.Wiki) {
+	rfc.SaveRfcsDone(w)
+}
+
+// StillOpen returns whether the RfC with the given ID is still open.
+func (s *rfcSource) StillOpen(w wiki.Wiki, title, rfcID string) bool {
+	if s.open == nil {
+		pages, err := w.EmbeddedIn("Template:Rfc")
+		if err != nil {
+			ybtools.PanicErr("Errored while querying for open RfCs with error: ", err)
+		}
+
+		s.open = map[string]bool{}
+		for _, page := range pages {
+			rfcs, _ := extractRfcs(page.Content, page.Title, false)
+			for _, rfc := range rfcs {
+				if rfc.ID != "" {
+					s.open[rfc.ID] = true
+				}
+			}
+		}
+	}
+	return s.open[rfcID]
+}
@@ -0,0 +1,117 @@
+package main
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"log"
+	"yapperbot-frs/src/report"
+	"yapperbot-frs/src/requestedmove"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+
+	"github.com/mashedkeyboard/ybtools/v2"
+)
+
+// rmSource is the requestSource for requested moves. It finds every page transcluding
+// {{Requested move/dated}}, and uses the on-wiki list of completed move IDs to work out
+// which discussions are new. If no page is configured for that list, it does nothing.
+type rmSource struct {
+	// open is the set of titles of the pages with move discussions open at the moment.
+	// It's only fetched if there's a message to retry that needs it, and then only once.
+	open map[string]bool
+}
+
+// Name returns the name of the source for logs.
+func (s *rmSource) Name() string {
+	return "Template:Requested move/dated"
+}
+
+// KnownHeader returns whether a FRS header is for requested moves.
+func (s *rmSource) KnownHeader(header string) bool {
+	return requestedmove.KnownHeader(header)
+}
+
+// RequestType returns the type of the requests from the source.
+func (s *rmSource) RequestType() string {
+	return requestedmove.Move{}.RequestType()
+}
+
+// Load loads the list of requested moves that have already been done.
+func (s *rmSource) Load(w wiki.Wiki) {
+	if yapperconfig.Config.RMsDonePageID == "" {
+		log.Println("No rmsdonepageid configured, so requested moves are disabled")
+		return
+	}
+	requestedmove.LoadMovesDone(w)
+}
+
+// Process gets a list of all open move discussions, and requests feedback for the ones we've not done yet.
+func (s *rmSource) Process(w wiki.Wiki, request func(frsRequesting)) {
+	if yapperconfig.Config.RMsDonePageID == "" {
+		return
+	}
+
+	pages, err := w.EmbeddedIn("Template:Requested move/dated")
+	if err != nil {
+		ybtools.PanicErr("Errored while querying for relevant new pages with error: ", err)
+	}
+	report.PagesScanned(s.Name(), len(pages))
+
+	for _, page := range pages {
+		// (content, title, excludeDone)
+		movesToProcess := extractMoves(page.Content, page.Title, false)
+		for _, move := range movesToProcess {
+			if move.FeedbackDone {
+				log.Println("Requested move feedback already done for a move on", page.Title, "so skipping that move")
+			} else {
+				log.Println("Requesting feedback for a requested move on", page.Title)
+				request(move)
+			}
+		}
+		if len(movesToProcess) > 0 {
+			requestedmove.MarkMovesDone(movesToProcess)
+		}
+	}
+}
+
+// Save saves the list of completed requested moves on-wiki.
+func (s *rmSource) Save(w wiki.Wiki) {
+	if yapperconfig.Config.RMsDonePageID == "" {
+		return
+	}
+	requestedmove.SaveMovesDone(w)
+}
+
+// StillOpen returns whether there's still a move discussion open on the page with the given title.
+func (s *rmSource) StillOpen(w wiki.Wiki, title, rfcID string) bool {
+	if s.open == nil {
+		pages, err := w.EmbeddedIn("Template:Requested move/dated")
+		if err != nil {
+			ybtools.PanicErr("Errored while querying for open requested moves with error: ", err)
+		}
+
+		s.open = map[string]bool{}
+		for _, page := range pages {
+			for _, move := range extractMoves(page.Content, page.Title, false) {
+				s.open[move.PageTitle()] = true
+			}
+		}
+	}
+	return s.open[title]
+}
@@ -0,0 +1,110 @@
+package main
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"yapperbot-frs/src/fac"
+	"yapperbot-frs/src/ga"
+	"yapperbot-frs/src/peerreview"
+	"yapperbot-frs/src/wiki"
+)
+
+// A requestSource is somewhere requests for feedback come from, such as RfCs or GA nominations.
+// Each source owns its own discovery query, its own deduplication and progress state, and
+// the extraction of its pages into frsRequesting values.
+type requestSource interface {
+	// Name returns a human-readable name for the source, for use in logs.
+	Name() string
+
+	// KnownHeader returns whether requests from this source could ever match a FRS header.
+	// It's used to lint the FRS list, and is only called after Load.
+	KnownHeader(header string) bool
+
+	// RequestType returns the type of the requests from this source, as they're given on messages.
+	RequestType() string
+
+	// StillOpen takes the title of the page a request is about and its RfC ID, if it's an RfC,
+	// and returns whether the request is still open. It's used to check that a message is still
+	// worth sending before retrying it, and is only called after Load.
+	StillOpen(w wiki.Wiki, title, rfcID string) bool
+
+	// Load sets up any state the source needs before it's processed,
+	// such as the list of requests that have already been done.
+	Load(w wiki.Wiki)
+
+	// Process discovers new requests, and passes each one to request.
+	Process(w wiki.Wiki, request func(frsRequesting))
+
+	// Save persists the source's deduplication and progress state. It's only
+	// run once processing has finished, so that we never record requests as
+	// done if we failed before getting as far as sending anything about them.
+	Save(w wiki.Wiki)
+}
+
+// requestSources is the registry of every source we process on each run, in order.
+// Adding a new review process to the FRS should only need a new source added here.
+var requestSources = []requestSource{
+	&rfcSource{},
+	&rmSource{},
+	&categorySource{
+		category:    "Category:Good article nominees",
+		load:        ga.FetchGATopics,
+		extract:     func(page wiki.Page) frsRequesting { return extractGANom(page.Content, page.Title) },
+		known:       ga.KnownHeader,
+		requestType: ga.Nom{}.RequestType(),
+	},
+	&categorySource{
+		category: "Category:Wikipedia featured article candidates",
+		// FACs are matched against the GA topics, so we need those loaded too
+		load:        ga.FetchGATopics,
+		extract:     func(page wiki.Page) frsRequesting { return extractFACNom(page.Content, page.Title) },
+		known:       fac.KnownHeader,
+		requestType: fac.Nom{}.RequestType(),
+	},
+	&categorySource{
+		category:    "Category:Current peer reviews",
+		extract:     func(page wiki.Page) frsRequesting { return extractPeerReview(page.Content, page.Title) },
+		known:       peerreview.KnownHeader,
+		requestType: peerreview.Nom{}.RequestType(),
+	},
+}
+
+// knownHeader returns whether any of our request sources could ever match a FRS header.
+func knownHeader(header string) bool {
+	for _, source := range requestSources {
+		if source.KnownHeader(header) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestStillOpen takes a Wiki, and returns a function for messages.QueueRetries that checks
+// whether a request is still open with the source its type comes from. Requests of a type
+// that none of our sources know about are treated as closed.
+func requestStillOpen(w wiki.Wiki) func(requestType, title, rfcID string) bool {
+	return func(requestType, title, rfcID string) bool {
+		for _, source := range requestSources {
+			if source.RequestType() == requestType {
+				return source.StillOpen(w, title, rfcID)
+			}
+		}
+		return false
+	}
+}
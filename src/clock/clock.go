@@ -0,0 +1,46 @@
+package clock
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import "time"
+
+// now is where the current time comes from. It's time.Now, unless it's been replaced with Set.
+var now = time.Now
+
+// Now returns the current time. Everything in the FRS should get the time from here,
+// rather than time.Now, so that the clock can be replaced to reproduce runs.
+func Now() time.Time {
+	return now()
+}
+
+// Since returns the time elapsed since t, by our clock.
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
+
+// Set replaces the clock with the given function. It's used to reproduce runs,
+// and to simulate things like month rollovers.
+func Set(clock func() time.Time) {
+	now = clock
+}
+
+// SetFixed stops the clock at the given time.
+func SetFixed(t time.Time) {
+	Set(func() time.Time { return t })
+}
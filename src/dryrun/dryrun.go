@@ -0,0 +1,169 @@
+package dryrun
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"yapperbot-frs/src/wiki"
+
+	"github.com/mashedkeyboard/ybtools/v2"
+	"github.com/metal3d/go-slugify"
+)
+
+// reportFilename is the name of the JSON report written into the dry run directory.
+const reportFilename string = "dryrun-report.json"
+
+// A PlannedEdit represents a single write that would have been made if the bot
+// weren't running in dry run mode. Kind is one of "edit", "newsection", "email" or "local",
+// the last of which covers files the bot would have written to disk.
+type PlannedEdit struct {
+	Kind         string `json:"kind"`
+	Page         string `json:"page"`
+	SectionTitle string `json:"sectiontitle,omitempty"`
+	Subject      string `json:"subject,omitempty"`
+	Summary      string `json:"summary,omitempty"`
+	Text         string `json:"text"`
+	// File is the name of the file in the dry run directory that holds Text,
+	// so that long pieces of wikitext or JSON can be read without unescaping.
+	File string `json:"file"`
+}
+
+// enabled indicates whether we're in a dry run; outputDir is where the report goes.
+var enabled bool
+var outputDir string
+
+// plannedEdits is our list of every write we would have made this run, in order.
+var plannedEdits []PlannedEdit
+
+// plannedEditsMux guards plannedEdits, in case we're ever recording from goroutines.
+var plannedEditsMux sync.Mutex
+
+// Enable turns on dry run mode, with the report and rendered edits going into dir.
+// The directory is created if it doesn't already exist.
+func Enable(dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		ybtools.PanicErr("Failed to create dry run directory ", dir, " with error ", err)
+	}
+	outputDir = dir
+	enabled = true
+	log.Println("Dry run mode enabled; nothing will be written to the wiki, and the report will go into", dir)
+}
+
+// Enabled returns whether the bot is running in dry run mode. Wiki edits are
+// caught by Wrap, but anything writing local state should check this first.
+func Enabled() bool {
+	return enabled
+}
+
+// dryRunWiki wraps a Wiki, passing reads through to it, but recording writes
+// into the dry run report rather than making them.
+type dryRunWiki struct {
+	wiki.Wiki
+}
+
+// Wrap takes a Wiki, and returns a Wiki which reads from it as normal, but records
+// every edit into the dry run report instead of making it.
+func Wrap(w wiki.Wiki) wiki.Wiki {
+	return dryRunWiki{w}
+}
+
+// EditPage records the edit, rather than making it.
+func (d dryRunWiki) EditPage(pageID, summary, text string) error {
+	RecordEdit("pageid:"+pageID, summary, text)
+	return nil
+}
+
+// EditRevision records the edit, rather than making it.
+func (d dryRunWiki) EditRevision(revision wiki.Revision, summary, text string) error {
+	RecordEdit("pageid:"+revision.PageID, summary, text)
+	return nil
+}
+
+// NewSection records the new section, rather than making it.
+func (d dryRunWiki) NewSection(title, sectionTitle, summary, text string) error {
+	RecordNewSection(title, sectionTitle, summary, text)
+	return nil
+}
+
+// EmailUser records the email, rather than sending it.
+func (d dryRunWiki) EmailUser(username, subject, text string) error {
+	RecordEmail("User:"+username, subject, text)
+	return nil
+}
+
+// RecordEdit records an edit that would have replaced the whole text of a page.
+func RecordEdit(page, summary, text string) {
+	record(PlannedEdit{Kind: "edit", Page: page, Summary: summary, Text: text})
+}
+
+// RecordNewSection records an edit that would have added a new section to a page.
+func RecordNewSection(page, sectionTitle, summary, text string) {
+	record(PlannedEdit{Kind: "newsection", Page: page, SectionTitle: sectionTitle, Summary: summary, Text: text})
+}
+
+// RecordEmail records an email that would have been sent to a user.
+func RecordEmail(user, subject, text string) {
+	record(PlannedEdit{Kind: "email", Page: user, Subject: subject, Text: text})
+}
+
+// RecordLocalWrite records a file that would have been written to the local disk.
+func RecordLocalWrite(filename, text string) {
+	record(PlannedEdit{Kind: "local", Page: filename, Text: text})
+}
+
+// WriteReport writes out the JSON report of every planned edit into the dry run directory.
+// It should be called once, at the very end of the run.
+func WriteReport() {
+	plannedEditsMux.Lock()
+	defer plannedEditsMux.Unlock()
+
+	// make sure we write [] rather than null if nothing was planned
+	if plannedEdits == nil {
+		plannedEdits = []PlannedEdit{}
+	}
+
+	reportJSON, err := json.MarshalIndent(plannedEdits, "", "  ")
+	if err != nil {
+		ybtools.PanicErr("Failed to serialize dry run report with error ", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outputDir, reportFilename), reportJSON, 0644); err != nil {
+		ybtools.PanicErr("Failed to write dry run report with error ", err)
+	}
+	log.Println("Dry run complete, wrote", len(plannedEdits), "planned edits to", outputDir)
+}
+
+// record adds the planned edit to our list, and writes its text out to its own file.
+func record(e PlannedEdit) {
+	plannedEditsMux.Lock()
+	defer plannedEditsMux.Unlock()
+
+	// number the files so they sort in the order the edits would have happened
+	e.File = fmt.Sprintf("%03d-%s-%s.txt", len(plannedEdits)+1, e.Kind, slugify.Marshal(e.Page))
+	if err := ioutil.WriteFile(filepath.Join(outputDir, e.File), []byte(e.Text), 0644); err != nil {
+		ybtools.PanicErr("Failed to write dry run edit for ", e.Page, " with error ", err)
+	}
+	plannedEdits = append(plannedEdits, e)
+	log.Println("Dry run: would have written", e.Kind, "to", e.Page)
+}
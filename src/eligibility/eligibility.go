@@ -0,0 +1,119 @@
+package eligibility
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"yapperbot-frs/src/clock"
+	"yapperbot-frs/src/report"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// w is the Wiki we look users up on. Until Init is called, everyone is eligible.
+var w wiki.Wiki
+
+// reasons caches, for every user we've checked this run, why they can't be messaged,
+// or empty string if they can be.
+var reasons = map[string]string{}
+
+// reasonsMux guards reasons.
+var reasonsMux sync.Mutex
+
+// Init sets the Wiki that users are looked up on, switching on eligibility checks.
+func Init(wiki wiki.Wiki) {
+	w = wiki
+}
+
+// Prefetch checks all of the given users that haven't been checked yet this run at once,
+// so that Eligible doesn't have to look them up one at a time.
+func Prefetch(usernames []string) {
+	if w == nil {
+		return
+	}
+
+	reasonsMux.Lock()
+	defer reasonsMux.Unlock()
+
+	var unchecked []string
+	seen := map[string]bool{}
+	for _, username := range usernames {
+		if _, checked := reasons[username]; !checked && !seen[username] {
+			unchecked = append(unchecked, username)
+			seen[username] = true
+		}
+	}
+	if len(unchecked) == 0 {
+		return
+	}
+
+	statuses, err := w.UserStatuses(unchecked)
+	if err != nil {
+		// we'd rather message someone we shouldn't than stop messaging everyone,
+		// so if we can't look users up, treat them as eligible for the rest of the run
+		log.Println("Failed to check whether users are eligible for messages, so treating them as eligible. The error was", err)
+	}
+
+	for _, username := range unchecked {
+		reason := ""
+		if status, ok := statuses[username]; ok {
+			reason = ineligibleReason(status)
+		}
+		reasons[username] = reason
+		if reason != "" {
+			log.Println("Excluding", username, "from this run:", reason)
+			report.UserExcluded(username, reason)
+		}
+	}
+}
+
+// Eligible returns whether a user can be sent messages, looking them up if we haven't already this run.
+func Eligible(username string) bool {
+	Prefetch([]string{username})
+
+	reasonsMux.Lock()
+	defer reasonsMux.Unlock()
+	return reasons[username] == ""
+}
+
+// ineligibleReason takes a user's status, and returns why they can't be messaged,
+// or empty string if they can be.
+func ineligibleReason(status wiki.UserStatus) string {
+	switch {
+	case status.Missing:
+		return "no such account"
+	case status.Blocked:
+		return "blocked"
+	case status.Locked:
+		return "globally locked"
+	}
+
+	if days := yapperconfig.Config.InactiveDays; days > 0 {
+		if status.LastEdit.IsZero() {
+			return "never edited"
+		}
+		if clock.Since(status.LastEdit) > time.Duration(days)*24*time.Hour {
+			return fmt.Sprintf("no edits in over %d days", days)
+		}
+	}
+	return ""
+}
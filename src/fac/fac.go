@@ -0,0 +1,77 @@
+package fac
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"strings"
+	"yapperbot-frs/src/ga"
+)
+
+// facPrefix is the comment at the start of each FAC topic header on the FRS list;
+// headers for FACs are in the form <!--fac-->Topic, using the same topics as GA.
+const facPrefix string = "<!--fac-->"
+
+// facAllPrefix marks the header which is the catch-all for every FAC.
+const facAllPrefix string = "<!--fac:all-->"
+
+const requestType string = "Featured Article nomination"
+
+// Nom represents a Featured Article nomination. FACs don't have a topic of their own,
+// so the topic is taken from the GA or article history templates on the talk page,
+// where there is one.
+type Nom struct {
+	Topic   string
+	Article string
+}
+
+// IncludeHeader determines if a given FRS header corresponds to this item correctly
+// Takes a string of the entire header (minus the === bits) and returns a bool for
+// if the header is included, and separately a bool indicating whether the header is the all
+// header or not.
+func (n Nom) IncludeHeader(header string) (bool, bool) {
+	if strings.HasPrefix(header, facAllPrefix) {
+		return true, true
+	}
+
+	if !strings.HasPrefix(header, facPrefix) || n.Topic == "" {
+		return false, false
+	}
+	headerSansPrefix := strings.TrimPrefix(header, facPrefix)
+
+	// the topic param on the templates can be either a GA topic or a subtopic,
+	// so check it against both, just like GA does
+	return headerSansPrefix == n.Topic || headerSansPrefix == ga.TopicForSubtopic(n.Topic), false
+}
+
+// KnownHeader returns whether a FRS header is for FACs in a known GA topic or subtopic, or for all FACs.
+// The GA topics must have been fetched first.
+func KnownHeader(header string) bool {
+	return strings.HasPrefix(header, facAllPrefix) ||
+		(strings.HasPrefix(header, facPrefix) && ga.IsTopic(strings.TrimPrefix(header, facPrefix)))
+}
+
+// PageTitle is a simple getter for the FAC article in order to make the interface work
+func (n Nom) PageTitle() string {
+	return n.Article
+}
+
+// RequestType returns the type this is - a FAC - so that it can be used in a template
+func (n Nom) RequestType() string {
+	return requestType
+}
@@ -19,18 +19,20 @@ package frslist
 //
 
 import (
+	"fmt"
 	"log"
-	"math/rand"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"yapperbot-frs/src/clock"
+	"yapperbot-frs/src/eligibility"
+	"yapperbot-frs/src/report"
+	"yapperbot-frs/src/wiki"
 	"yapperbot-frs/src/yapperconfig"
 
-	"cgt.name/pkg/go-mwclient"
-	"cgt.name/pkg/go-mwclient/params"
 	"github.com/mashedkeyboard/ybtools/v2"
 )
 
@@ -41,47 +43,104 @@ var list map[string][]*FRSUser
 // It's used to keep track of which headers we have.
 var listHeaders []string
 
-// frsWeightedUser extends FRSUser to add a weighting component. It's only used within frslist.
-type frsWeightedUser struct {
-	*FRSUser
-	// weight will represent our probability for this user to be selected
-	weight float64
-	// _hasAllHeaderChecked is a simple boolean check to make sure we don't halve the probability twice
-	_hasAllHeaderChecked bool
+// sentCount maps headers down to users, and then users down to the number of messages they've received this month.
+var sentCount map[string]map[string]uint16 // {header: {user: count sent}}
+// initialSentCount is a copy of sentCount as it was loaded at the start of the run,
+// used to work out how much each count changed for the run report.
+var initialSentCount map[string]map[string]uint16
+
+// history maps previous months, in the form 2006-01, to the sentCount from the end of that month.
+var history map[string]map[string]map[string]uint16 // {month: {header: {user: count sent}}}
+
+// defaultHistoryMonths is how many previous months of sent counts we keep if it's not configured.
+const defaultHistoryMonths int = 12
+
+// sentTimes maps headers down to users, and then users down to the times they were sent each message
+// in the last week - or the rolling window, if that's longer - so that the weekly and daily caps and
+// rolling limits can be enforced. Unlike sentCount, this doesn't reset each month.
+var sentTimes map[string]map[string][]time.Time // {header: {user: [times sent]}}
+
+// week and day are the windows the weekly and daily caps apply over.
+const week time.Duration = 7 * 24 * time.Hour
+const day time.Duration = 24 * time.Hour
+
+// rollingWindow returns the rolling window that limits apply over, and whether one is configured.
+// If there isn't one, limits apply over calendar months.
+func rollingWindow() (time.Duration, bool) {
+	days := yapperconfig.Config.RollingWindowDays
+	return time.Duration(days) * day, days > 0
 }
 
-// checkWeightForAllHeader takes a string representation of the "All [type]s" header, and checks whether
-// the frsWeightedUser is contained within that header. If it is, it will halve the user's weighting, to
-// encourage users subscribed to specific categories to be selected.
-func (u *frsWeightedUser) checkWeightForAllHeader(allHeader string) {
-	if !u._hasAllHeaderChecked {
-		// if the all header is set, give those users half the probability of receiving the message.
-		// we should try and make sure our messages are being sent to specific categories more of the time,
-		// but we should still make sure users under the all headers receive messages.
-		// this needs to be done here so that they are ordered correctly; as we're later inverting the probabilities,
-		// the weight also has to be doubled, not halved, counterintuitively
-		if allHeader != "" && u.Header == allHeader {
-			u.weight = u.weight * 2
-		}
-		u._hasAllHeaderChecked = true
+// sentTimesRetention returns how long we need to keep the times messages were sent for;
+// that's a week for the weekly caps, or the rolling window if that's longer.
+func sentTimesRetention() time.Duration {
+	if window, rolling := rollingWindow(); rolling && window > week {
+		return window
 	}
+	return week
 }
 
-// sentCount maps headers down to users, and then users down to the number of messages they've received this month.
-var sentCount map[string]map[string]uint16 // {header: {user: count sent}}
-// sentCountMux is a simple mutex to make sure that, if we ever add goroutines, we don't start overwriting
-// SentCount simultaneously.
+// LimitPeriod returns a description of the period that limits apply over, for edit summaries.
+func LimitPeriod() string {
+	if _, rolling := rollingWindow(); rolling {
+		return fmt.Sprintf("in the last %d days", yapperconfig.Config.RollingWindowDays)
+	}
+	return "this month"
+}
+
+// lastSent maps usernames to the last time they were sent a message, across all headers.
+var lastSent map[string]time.Time
+
+// initialLastSent is a copy of lastSent as it was loaded at the start of the run, so that
+// we can put it back if sending a user their messages fails.
+var initialLastSent map[string]time.Time
+
+// sentCountMux is a simple mutex to make sure that, as messages are sent from several goroutines,
+// we don't start overwriting SentCount simultaneously.
 var sentCountMux sync.Mutex
 
+// untilFormat is the date format for the until= parameter on subscriptions.
+const untilFormat string = "2006-01-02"
+
+// sentCountEditSummary is the edit summary used when saving the sentcounts.
+const sentCountEditSummary string = "FRS run complete, updating sentcounts"
+
+// held maps headers down to users, and then users down to the number of messages being held for
+// their digests. The held messages themselves are kept in the state store by the messages package,
+// which marks each of them here at the start of the run. They're not sent yet, so they're not in
+// sentCount, but they're counted when picking users, so that a digest subscriber isn't picked for
+// more messages than their limits allow.
+var held map[string]map[string]uint16 // {header: {user: count held}}
+
+// totalLimits maps usernames to the overall limit they've set on the number of messages
+// they receive each month, across every header they're subscribed to. Users without
+// an overall limit aren't in the map.
+var totalLimits map[string]uint16
+
+// DeliveryTalkPage and DeliveryEmail are the ways a user can choose to get their messages,
+// with delivery= on their subscriptions.
+const (
+	DeliveryTalkPage string = "talk"
+	DeliveryEmail    string = "email"
+)
+
+// DigestDaily and DigestWeekly are how often a user can choose to have their messages for a header
+// bundled up and sent to them, with digest= on the subscription.
+const (
+	DigestDaily  string = "daily"
+	DigestWeekly string = "weekly"
+)
+
+// deliveryPreferences maps usernames to how they'd like to get their messages, where they've
+// said so. Users who haven't are sent messages on their talk page.
+var deliveryPreferences map[string]string
+
 // listParserRegex looks at the Feedback Request Service list, and finds each header and its users.
 var listParserRegex *regexp.Regexp
 
 // userParserRegex looks over the contents of a FRS list header, and finds each user within the header.
 var userParserRegex *regexp.Regexp
 
-// randomGenerator is our random number generator for this function, separated so we can separately seed it.
-var randomGenerator *rand.Rand
-
 func init() {
 	// This regex matches on the Feedback Request Service list.
 	// The first group matches the header (minus the ===s)
@@ -91,18 +150,42 @@ func init() {
 	// This regex matches each user individually in a section of the FRS list.
 	// The first group matches the user name
 	// The second group matches the requested limit
-	userParserRegex = regexp.MustCompile(`(?i){{frs user\|([^|]*)(?:\|(\d+))?}}`)
+	// The third group matches any named parameters, each with its leading pipe; see parseNamedParams.
+	// Those are total= for the overall limit, week= and day= for the short caps,
+	// until= and paused= for pausing the subscription, delivery= for how to send messages,
+	// and digest= for bundling them up.
+	userParserRegex = regexp.MustCompile(`(?i){{frs user\|([^|}]*)(?:\|(\d+))?((?:\|[^|}=]*=[^|}]*)*)}}`)
 
-	randomGenerator = rand.New(rand.NewSource(time.Now().UnixNano()))
+	reset()
+}
 
+// reset empties the list and the sent counts, ready for them to be populated.
+func reset() {
 	list = map[string][]*FRSUser{}
+	listHeaders = nil
+	held = map[string]map[string]uint16{}
+	totalLimits = map[string]uint16{}
+	deliveryPreferences = map[string]string{}
 	sentCount = map[string]map[string]uint16{}
+	history = map[string]map[string]map[string]uint16{}
+	sentTimes = map[string]map[string][]time.Time{}
+	lastSent = map[string]time.Time{}
 }
 
 // Populate sets up the FRSList list as appropriate for the start of the program.
-func Populate() {
-	populateFrsList()
-	populateSentCount()
+// Anything left over from an earlier call is thrown away first, so the simulator
+// can populate it afresh for each of its runs.
+func Populate(w wiki.Wiki) {
+	reset()
+	populateFrsList(w)
+	populateSentCount(w)
+}
+
+// GetHistory returns the archived sent counts for previous months, mapping months in the form
+// 2006-01 to headers, headers to usernames, and usernames to the number of messages they were sent
+// that month. Only the configured number of months are kept. It shouldn't be modified.
+func GetHistory() map[string]map[string]map[string]uint16 {
+	return history
 }
 
 // GetListHeaders is a simple getter for listHeaders
@@ -110,169 +193,96 @@ func GetListHeaders() []string {
 	return listHeaders
 }
 
-// GetUsersFromHeaders takes a list of headers and an integer number of users n, and returns a randomly selected portion of the users
-// from the headers, with a total size of maximum n. It won't pick the same user twice, and weights the users based on how far through their limit
-// they are, in an attempt to spread things out a bit. It may pick less than n if there are less users available.
-func GetUsersFromHeaders(headers []string, allHeader string, n int) (returnedUsers []*FRSUser) {
-	var weightedUsers []*frsWeightedUser
-	// used to check in o(1) time whether we've already
-	// selected this user, just on another header
-	var usersSelected = map[string]bool{}
-
-	// start our returnedUsers off with a zero-length slice of cap n
-	returnedUsers = make([]*FRSUser, 0, n)
-
-	// unlimitedUsers stores all of our users who have no limit set.
-	// calculatedWeights stores all of the weights that we have otherwise
-	// calculated.
-	// these two are used to later calculate the median of the calculated weights,
-	// and set each of the unlimited users to be weighted the same as the median.
-	var unlimitedUsers []*frsWeightedUser
-	var calculatedWeights []float64
-
-	// Get a list of all the eligible users in the header
-	for _, header := range headers {
-		for _, user := range list[header] {
-			if !user.ExceedsLimit() {
-				var weight float64
-				if user.Limited {
-					if user.GetCount() == 0 {
-						// if the user has not been sent anything, prioritise them for
-						// sending; this seems a reasonable way of weighting
-						weight = 0
-					} else {
-						// the user has been sent something, and has a limit set.
-						// weight them on the basis of their relative position
-						// within their limit. this means users with a higher limit
-						// will be more likely to receive more messages than users
-						// with a lower limit, and vice versa;
-						// however, it also keeps users with high limits from receiving
-						// all the messages, when other users are lacking anything sent.
-						weight = float64(user.GetCount()) / float64(user.Limit)
-					}
-
-					// shift each weight forward by 1 to avoid issues with dividing by zero, and to avoid
-					// zero probabilities; we want users with no current sent messages to be top-priority,
-					// definitely not zero priority
-					weight = weight + 1
-
-					// check the user for inclusion in the allHeader, halve their probability if they are,
-					// and then append them to the list of users
-					wUser := &frsWeightedUser{FRSUser: user, weight: weight}
-					wUser.checkWeightForAllHeader(allHeader)
-					weightedUsers = append(weightedUsers, wUser)
-				} else {
-					// if the user has no limit set, add them to unlimitedUsers as well as weightedUsers;
-					// we'll set their weight to the median weight later
-					wUser := &frsWeightedUser{FRSUser: user}
-					unlimitedUsers = append(unlimitedUsers, wUser)
-					weightedUsers = append(weightedUsers, wUser)
-				}
-			}
-		}
-	}
+// GetUsersInHeader returns every user subscribed to a header.
+func GetUsersInHeader(header string) []*FRSUser {
+	return list[header]
+}
 
-	// If there are any users in the header who have no limits set, set their weighting to the median of the limited users
-	if len(unlimitedUsers) > 0 {
-		median, hasMedian := calculateMedian(calculatedWeights)
-		if !hasMedian {
-			// if all users are unlimited, then just set their weights to 1 -
-			// they're all the same anyway then, so it doesn't make a difference
-			median = 1
-		}
-		for _, user := range unlimitedUsers {
-			user.weight = median
-			// even for unlimited users, we want to give people in specific category headers more of a chance,
-			// so we should still run the AllHeader weight check here
-			user.checkWeightForAllHeader(allHeader)
+// GetSubscription returns the user's subscription to a header, or nil if they're not subscribed to it.
+func GetSubscription(username, header string) *FRSUser {
+	for _, user := range list[header] {
+		if user.Username == username {
+			return user
 		}
 	}
+	return nil
+}
 
-	// Check if we actually have an opportunity to randomly select at all here
-	if len(weightedUsers) <= n {
-		// very small list, or very large n
-		// just give the entire list
-		for _, wuser := range weightedUsers {
-			returnedUsers = append(returnedUsers, wuser.FRSUser)
+// GetUsersFromHeaders takes a list of headers, the header which is the catch-all for the request (if any),
+// the type of the request and an integer number of users n, and returns a selected portion of the users
+// from the headers, with a total size of maximum n. It won't pick anyone who's reached their limits
+// or paused their subscription, anyone eligibility says can't be messaged, or the same user twice. How the users are picked depends on the SelectionStrategy configured for
+// the headers or request type; see strategyFor. It may pick less than n if there are less users available.
+func GetUsersFromHeaders(headers []string, allHeader string, requestType string, n int) []*FRSUser {
+	// Get a list of all the users in the headers who are under their limits and haven't paused
+	var candidates []*FRSUser
+	for _, header := range headers {
+		for _, user := range list[header] {
+			if !user.IsPaused() && !user.ExceedsLimitWithHeld() {
+				candidates = append(candidates, user)
+			}
 		}
-		return
-	}
-
-	// Sort the list into increasing order of sent count this month
-	sort.Slice(weightedUsers, func(i, j int) bool {
-		return weightedUsers[i].weight < weightedUsers[j].weight
-	})
-
-	// Calculate cumulative sent counts for the users
-	var cumulativeSentCount float64
-
-	for _, user := range weightedUsers {
-		// take the reciprocal of the weight, and use it cumulatively.
-		// we do this here to ensure that our sent counts are used
-		// as a decentive for sending new messages.
-		cumulativeSentCount += 1 / user.weight
-		weight := float64(cumulativeSentCount)
-
-		user.weight = weight
 	}
 
-	// Reseed to avoid getting the same or similar sequences every time
-	// when we have large batches
-	randomGenerator.Seed(time.Now().UnixNano())
-
-	// Select a random user each time based on our weights
-	var i = 0
-	for i < n {
-		// adjust our random value to be within our bounds - going up to the
-		// final weight as a maximum value possible
-		randomValue := randomGenerator.Float64() * weightedUsers[len(weightedUsers)-1].weight
-
-		selectedUserIndex := sort.Search(len(weightedUsers), func(i int) bool {
-			// find the smallest weight user whose weight is greater than our random selection
-			return weightedUsers[i].weight > randomValue
-		})
-
-		if selectedUserIndex > len(weightedUsers)-1 {
-			// the selection index hasn't been found; this is probably because we've exhausted our search somehow
-			// just return what we've got, and mark in the log that this happened - it shouldn't ever happen
-			log.Println("WARNING: Exhausted search space for selectedUserIndex, returning what we can get: asked for", n, "and got", len(returnedUsers))
-			return
+	// Looking users up takes queries, so rather than checking every candidate, only check whether the ones
+	// picked are blocked, locked or inactive. If any of them are, drop them and pick again, until everyone
+	// picked can be messaged; the strategies don't keep any state, so picking again is just like having
+	// dropped those users to start with.
+	strategy := strategyFor(headers, requestType)
+	for {
+		selected := strategy.Select(candidates, allHeader, n)
+
+		var usernames []string
+		for _, user := range selected {
+			usernames = append(usernames, user.Username)
 		}
+		eligibility.Prefetch(usernames)
 
-		// make sure we haven't already picked this user
-		if !usersSelected[weightedUsers[selectedUserIndex].Username] {
-			// assuming we haven't, add them to our list...
-			returnedUsers = append(returnedUsers, weightedUsers[selectedUserIndex].FRSUser)
-			// mark them as picked...
-			usersSelected[weightedUsers[selectedUserIndex].Username] = true
-			// and increase the number of users we've picked up to n
-			i++
+		ineligible := map[string]bool{}
+		for _, username := range usernames {
+			if !eligibility.Eligible(username) {
+				ineligible[username] = true
+			}
+		}
+		if len(ineligible) == 0 {
+			return selected
 		}
 
-		// make sure we're not keeping the same users around, potentially selecting them
-		// multiple times
-		if selectedUserIndex+1 == len(weightedUsers) {
-			// this is the end of the slice; just chop the end off
-			weightedUsers = weightedUsers[:selectedUserIndex]
-		} else {
-			// we need to chop this element, and only this element, out
-			weightedUsers = append(weightedUsers[:selectedUserIndex], weightedUsers[selectedUserIndex+1:]...)
+		var remaining []*FRSUser
+		for _, user := range candidates {
+			if !ineligible[user.Username] {
+				remaining = append(remaining, user)
+			}
 		}
+		candidates = remaining
 	}
-
-	return
 }
 
-// FinishRun for now just calls saveSentCounts, but could do something else too in future
-func FinishRun(w *mwclient.Client) {
+// FinishRun reports how the sent counts changed over the run, and then calls saveSentCounts.
+func FinishRun(w wiki.Wiki) {
+	reportSentCountDeltas()
 	saveSentCounts(w)
 }
 
+// reportSentCountDeltas adds the change in every sent count since the start of the run to the run report.
+func reportSentCountDeltas() {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+
+	for header, users := range sentCount {
+		for user, count := range users {
+			if delta := int(count) - int(initialSentCount[header][user]); delta != 0 {
+				report.SentCountDelta(header, user, delta)
+			}
+		}
+	}
+}
+
 // populateFrsList fetches the wikitext of the FRS subscriptions page, and processes the page against
 // the listParserRegex and userParserRegex. Together, those parse the headers in the file, along with
 // the users that are subscribed, turning them into FRSUser objects and storing them in `list`.
-func populateFrsList() string {
-	text, err := ybtools.FetchWikitext(yapperconfig.Config.FRSPageID)
+func populateFrsList(w wiki.Wiki) string {
+	text, err := w.FetchWikitext(yapperconfig.Config.FRSPageID)
 	if err != nil {
 		ybtools.PanicErr("Failed to fetch and parse FRS page with error ", err)
 	}
@@ -281,103 +291,278 @@ func populateFrsList() string {
 		// match is [entire match, header, contents]
 		var users []*FRSUser
 		for _, usermatched := range userParserRegex.FindAllStringSubmatch(match[2], -1) {
-			// usermatched is [entire match, user name, requested limit]
+			// usermatched is [entire match, user name, requested limit, named params]
+			namedParams := parseNamedParams(usermatched[3])
+			if total, ok := namedParams["total"]; ok {
+				setTotalLimit(usermatched[1], total)
+			}
+			if delivery, ok := namedParams["delivery"]; ok {
+				setDeliveryPreference(usermatched[1], delivery)
+			}
+
+			var user *FRSUser
 			if usermatched[2] == "0" {
 				// The user has explicitly requested no limit
 				// we only need to set the username; bool default is false, and numeric default is zero
-				users = append(users, &FRSUser{Username: usermatched[1], Header: match[1]})
+				user = &FRSUser{Username: usermatched[1], Header: match[1]}
 			} else if usermatched[2] != "" {
 				// The user has a limit set
 				if limit, err := strconv.ParseInt(usermatched[2], 10, 16); err == nil {
-					users = append(users, &FRSUser{Username: usermatched[1], Header: match[1], Limit: uint16(limit), Limited: true})
+					user = &FRSUser{Username: usermatched[1], Header: match[1], Limit: uint16(limit), Limited: true}
 				} else {
 					log.Println("User", usermatched[1], "has an invalid limit of", usermatched[2], "so ignoring")
+					continue
 				}
 			} else {
 				// The user does not have a set limit
 				// Use the default value of 1
-				users = append(users, &FRSUser{Username: usermatched[1], Header: match[1], Limit: 1, Limited: true})
+				user = &FRSUser{Username: usermatched[1], Header: match[1], Limit: 1, Limited: true}
 			}
+
+			setPause(user, namedParams)
+			user.WeeklyLimit = parseCap(user.Username, "week", namedParams)
+			user.DailyLimit = parseCap(user.Username, "day", namedParams)
+			user.Digest = parseDigest(user.Username, namedParams)
+			users = append(users, user)
+		}
+		if _, exists := list[match[1]]; !exists {
+			// keep the headers in the order they're on the page, rather than map order,
+			// so that a seeded run always considers them in the same order
+			listHeaders = append(listHeaders, match[1])
 		}
 		list[match[1]] = users
 	}
 
-	listHeaders = make([]string, len(list))
-	i := 0
-	for header := range list {
-		listHeaders[i] = header
-		i++
+	return text
+}
+
+// parseNamedParams takes the named parameters matched by userParserRegex, each with its leading
+// pipe, e.g. "|total=10|foo=bar", and returns a map of parameter names to their values.
+// Parameter names are lowercased, and both names and values have whitespace trimmed.
+func parseNamedParams(params string) map[string]string {
+	parsed := map[string]string{}
+	for _, param := range strings.Split(params, "|") {
+		if splitParam := strings.SplitN(param, "=", 2); len(splitParam) == 2 {
+			parsed[strings.ToLower(strings.TrimSpace(splitParam[0]))] = strings.TrimSpace(splitParam[1])
+		}
+	}
+	return parsed
+}
+
+// setTotalLimit takes a username and the value of a total= parameter from one of their subscriptions,
+// and sets their overall limit. If different subscriptions set different totals, the lowest wins.
+// A total of 0 means no overall limit, the same as a limit of 0 on a subscription or a week= or day=
+// cap of 0, so it's not stored.
+func setTotalLimit(username, total string) {
+	limit, err := strconv.ParseUint(total, 10, 16)
+	if err != nil {
+		log.Println("User", username, "has an invalid total limit of", total, "so ignoring")
+		return
 	}
+	if limit == 0 {
+		return
+	}
+	if existing, ok := totalLimits[username]; !ok || uint16(limit) < existing {
+		totalLimits[username] = uint16(limit)
+	}
+}
 
-	return text
+// setDeliveryPreference takes a username and the value of a delivery= parameter from one of their
+// subscriptions, and sets how they'd like to get their messages. As a user's messages all go out
+// together, if any of their subscriptions asks for email, they get email.
+func setDeliveryPreference(username, delivery string) {
+	switch delivery = strings.ToLower(delivery); delivery {
+	case DeliveryEmail:
+		deliveryPreferences[username] = delivery
+	case DeliveryTalkPage:
+		if deliveryPreferences[username] == "" {
+			deliveryPreferences[username] = delivery
+		}
+	default:
+		log.Println("User", username, "has an invalid delivery preference of", delivery, "so ignoring")
+	}
+}
+
+// GetDeliveryPreference returns how a user would like to get their messages,
+// either DeliveryTalkPage or DeliveryEmail.
+func GetDeliveryPreference(username string) string {
+	if preference, ok := deliveryPreferences[username]; ok {
+		return preference
+	}
+	return DeliveryTalkPage
+}
+
+// parseDigest takes a username and the named parameters on a subscription, and returns
+// how often they'd like their messages for it bundled up, or empty string if they wouldn't.
+func parseDigest(username string, namedParams map[string]string) string {
+	digest, ok := namedParams["digest"]
+	if !ok {
+		return ""
+	}
+	switch lower := strings.ToLower(digest); lower {
+	case DigestDaily, DigestWeekly:
+		return lower
+	}
+	log.Println("User", username, "has an invalid digest of", digest, "so ignoring")
+	return ""
+}
+
+// DigestPeriod takes DigestDaily or DigestWeekly, and returns how long messages are held for
+// before they're sent in a digest.
+func DigestPeriod(digest string) time.Duration {
+	if digest == DigestWeekly {
+		return week
+	}
+	return day
+}
+
+// setPause takes a subscription and its named parameters, and sets up its pause from the
+// paused= and until= parameters. An until= date that can't be parsed is ignored.
+func setPause(user *FRSUser, namedParams map[string]string) {
+	if paused, ok := namedParams["paused"]; ok {
+		user.Paused = isYes(paused)
+	}
+	if until, ok := namedParams["until"]; ok {
+		if resumes, err := parseUntil(until); err == nil {
+			user.PausedUntil = resumes
+		} else {
+			log.Println("User", user.Username, "has an invalid until date of", until, "so ignoring")
+		}
+	}
+}
+
+// parseCap takes a username, the name of a cap parameter and the named parameters on a subscription,
+// and returns the value of the cap, or zero if it's not set or invalid.
+func parseCap(username, param string, namedParams map[string]string) uint16 {
+	value, ok := namedParams[param]
+	if !ok {
+		return 0
+	}
+	limit, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		log.Println("User", username, "has an invalid", param, "cap of", value, "so ignoring")
+		return 0
+	}
+	return uint16(limit)
+}
+
+// parseUntil takes the value of an until= parameter, a date in the form 2006-01-02, and returns
+// the time the subscription should resume - the start of the next day, so that the subscription
+// stays paused for the whole of the date given.
+func parseUntil(until string) (time.Time, error) {
+	date, err := time.Parse(untilFormat, until)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return date.AddDate(0, 0, 1), nil
+}
+
+// isYes returns whether a template parameter value is one of the usual ways of saying yes.
+func isYes(value string) bool {
+	switch strings.ToLower(value) {
+	case "yes", "y", "true", "1":
+		return true
+	}
+	return false
 }
 
 // populateSentCount fetches the SentCount page, and checks it's of the right month.
 // If it's a previous month, then it just leaves the `sentCount` map blank; if it's
 // the same month listed on the JSON file, it will parse the JSON and load it into `sentCount`.
-func populateSentCount() {
+// The times each message was sent are always loaded, as the caps and any rolling window
+// don't follow calendar months.
+func populateSentCount(w wiki.Wiki) {
 	// This is stored on the page with ID sentCountPageID.
 	// It is made up of something that looks like this:
-	// {"month": "2020-05", "headers": {"category": {"username": 8}}}
-	// where username had been sent 8 messages in the month of May 2020 and the header "category".
-	parsedJSON := ybtools.LoadJSONFromPageID(yapperconfig.Config.SentCountPageID)
+	// {"month": "2020-05", "headers": {"category": {"username": 8}},
+	//  "sent": {"category": {"username": ["2020-05-19T12:00:00Z", "2020-05-20T12:00:00Z"]}},
+	//  "lastsent": {"username": "2020-05-20T12:00:00Z"}, "history": {"2020-04": {"category": {"username": 3}}}}
+	// where username had been sent 8 messages in the month of May 2020 and the header "category",
+	// two of which were sent in the last week, the last of those at midday on the 20th, and 3 the month before.
+	parsedJSON := wiki.LoadJSONFromPageID(w, yapperconfig.Config.SentCountPageID)
 
 	contentMonth, _ := parsedJSON.GetString("month")
 	// yes, really, you have to specify time formats with a specific time in Go
 	// *rolls eyes*
 	// https://golang.org/pkg/time/#Time.Format
-	if contentMonth != time.Now().Format("2006-01") {
+	history = deserializeHistory(parsedJSON)
+	if contentMonth != clock.Now().Format("2006-01") {
 		log.Println("contentMonth is not the current month, so data resets!")
+		if contentMonth != "" {
+			// keep last month's counts, so that we can see how messages were spread over time
+			history[contentMonth] = deserializeSentCount(parsedJSON)
+		}
 	} else {
 		sentCount = deserializeSentCount(parsedJSON)
 	}
+	pruneHistory()
+
+	// sent times aren't monthly, so always load them
+	sentTimes = deserializeSentTimes(parsedJSON)
+	lastSent = deserializeLastSent(parsedJSON)
+
+	// take copies to compare against at the end of the run
+	initialLastSent = map[string]time.Time{}
+	for user, sent := range lastSent {
+		initialLastSent[user] = sent
+	}
+	initialSentCount = map[string]map[string]uint16{}
+	for header, users := range sentCount {
+		initialSentCount[header] = map[string]uint16{}
+		for user, count := range users {
+			initialSentCount[header][user] = count
+		}
+	}
+}
+
+// pruneHistory drops all but the newest months from history, keeping as many as are configured.
+func pruneHistory() {
+	keep := yapperconfig.Config.SentCountHistoryMonths
+	if keep == 0 {
+		keep = defaultHistoryMonths
+	}
+
+	months := make([]string, 0, len(history))
+	for month := range history {
+		months = append(months, month)
+	}
+	// months are in the form 2006-01, so they sort correctly as strings
+	sort.Sort(sort.Reverse(sort.StringSlice(months)))
+	for i, month := range months {
+		if i >= keep {
+			delete(history, month)
+		}
+	}
 }
 
 // saveSentCounts serializes our `sentCount` map into JSON, so we can save it on-wiki
 // and load it again when we need to for the next run.
-func saveSentCounts(w *mwclient.Client) {
+func saveSentCounts(w wiki.Wiki) {
 	var sentCountJSONBuilder strings.Builder
 	sentCountJSONBuilder.WriteString(yapperconfig.OpeningJSON)
 	sentCountJSONBuilder.WriteString(`"month":"`)
-	sentCountJSONBuilder.WriteString(time.Now().Format("2006-01"))
+	sentCountJSONBuilder.WriteString(clock.Now().Format("2006-01"))
 	sentCountJSONBuilder.WriteString(`","headers":`)
 	sentCountJSONBuilder.WriteString(ybtools.SerializeToJSON(sentCount))
+	sentCountJSONBuilder.WriteString(`,"history":`)
+	sentCountJSONBuilder.WriteString(ybtools.SerializeToJSON(history))
+	sentCountJSONBuilder.WriteString(`,"sent":`)
+	sentCountJSONBuilder.WriteString(ybtools.SerializeToJSON(serializeSentTimes()))
+	sentCountJSONBuilder.WriteString(`,"lastsent":`)
+	sentCountJSONBuilder.WriteString(ybtools.SerializeToJSON(serializeLastSent()))
 	sentCountJSONBuilder.WriteString(yapperconfig.ClosingJSON)
 
 	// this is in userspace, and it's really desperately necessary - do not count this for edit limiting
-	// for the same reason, we have no maxlag wait - we need this to run under all circumstances, to ensure
+	// for the same reason, EditPage has no maxlag wait - we need this to run under all circumstances, to ensure
 	// that people's limits are respected
-	ybtools.NoMaxlagDo(func() (err error) {
-		err = w.Edit(params.Values{
-			"pageid":   yapperconfig.Config.SentCountPageID,
-			"summary":  "FRS run complete, updating sentcounts",
-			"notminor": "true",
-			"bot":      "true",
-			"text":     sentCountJSONBuilder.String(),
-		})
-		if err == nil {
-			log.Println("Successfully updated sentcounts")
+	err := w.EditPage(yapperconfig.Config.SentCountPageID, sentCountEditSummary, sentCountJSONBuilder.String())
+	if err == nil {
+		log.Println("Successfully updated sentcounts")
+	} else {
+		if err.Error() == "edit successful, but did not change page" {
+			log.Println("WARNING: Successfully updated sentcounts, but they didn't change - if anything was done this session, something is wrong!")
 		} else {
-			if err.Error() == "edit successful, but did not change page" {
-				log.Println("WARNING: Successfully updated sentcounts, but they didn't change - if anything was done this session, something is wrong!")
-			} else {
-				ybtools.PanicErr("Failed to update sentcounts with error ", err)
-			}
-		}
-		return
-	}, w)
-}
-
-// calculateMedian takes a slice of float64s and returns the median if there is one, and a bool indicating if a median
-// could be calculated (i.e. if the given slice has a length greater than zero).
-func calculateMedian(calculatedWeights []float64) (float64, bool) {
-	if len(calculatedWeights) > 0 {
-		sort.Float64s(calculatedWeights)
-		middleIndex := len(calculatedWeights) / 2
-		if len(calculatedWeights)%2 == 0 {
-			return (calculatedWeights[middleIndex-1] + calculatedWeights[middleIndex]) / 2, true
+			ybtools.PanicErr("Failed to update sentcounts with error ", err)
 		}
-		return calculatedWeights[middleIndex], true
 	}
-	return 0, false
 }
@@ -0,0 +1,194 @@
+//go:build fakewiki
+// +build fakewiki
+
+package frslist
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These are behind the fakewiki build tag because this package needs the ybtools config files
+// to load, so they're run by the e2e package, which provides them.
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"yapperbot-frs/src/clock"
+	"yapperbot-frs/src/eligibility"
+	"yapperbot-frs/src/random"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// testList is the FRS list the tests populate from, with five users under the one header.
+const testList string = `===Biographies===
+*{{frs user|Example one|2}}
+*{{frs user|Example two|2}}
+*{{frs user|Example three|2}}
+*{{frs user|Example four|2}}
+*{{frs user|Example five|2}}
+`
+
+// populateTest pins the clock at now, and populates the list from a FakeWiki holding testList
+// and the sent counts given, which go after the opening of the JSON. The config and the clock
+// are put back when the test finishes.
+func populateTest(t *testing.T, now string, sentCounts string) *wiki.FakeWiki {
+	oldConfig := yapperconfig.Config
+	t.Cleanup(func() {
+		yapperconfig.Config = oldConfig
+		clock.Set(time.Now)
+	})
+	yapperconfig.Config.FRSPageID = "1"
+	yapperconfig.Config.SentCountPageID = "2"
+
+	fake := wiki.NewFakeWiki()
+	fake.AddPage(wiki.FakePage{ID: "1", Title: "Wikipedia:Feedback request service", Content: testList})
+	fake.AddPage(wiki.FakePage{ID: "2", Title: "User:FRS test/sentcount.json", Content: yapperconfig.OpeningJSON + sentCounts + yapperconfig.ClosingJSON})
+	setClock(t, now)
+	Populate(fake)
+	return fake
+}
+
+// setClock pins the clock at the given RFC3339 time.
+func setClock(t *testing.T, now string) {
+	pinned, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.SetFixed(pinned)
+}
+
+// usernames returns the usernames of a list of subscriptions, in order.
+func usernames(users []*FRSUser) (names []string) {
+	for _, user := range users {
+		names = append(names, user.Username)
+	}
+	return
+}
+
+func TestGetUsersFromHeadersIsReproducible(t *testing.T) {
+	// Example five has already had their two messages this month, so can't be picked
+	sentCounts := `"month":"2020-06","headers":{"Biographies":{"Example one":1,"Example five":2}}`
+	want := []string{"Example four", "Example two"}
+
+	for run := 0; run < 3; run++ {
+		populateTest(t, "2020-06-15T12:00:00Z", sentCounts)
+		random.Seed(20200615)
+		if got := usernames(GetUsersFromHeaders([]string{"Biographies"}, "", "request for comment", 2)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d picked %q, want %q", run, got, want)
+		}
+	}
+}
+
+func TestSentCountsResetAtMonthBoundary(t *testing.T) {
+	sentCounts := `"month":"2020-05","headers":{"Biographies":{"Example one":2}}`
+
+	populateTest(t, "2020-05-31T23:59:59Z", sentCounts)
+	user := GetSubscription("Example one", "Biographies")
+	if count := user.GetCount(); count != 2 || !user.ExceedsLimit() {
+		t.Errorf("at the end of May, got count %d, want 2 and over the limit", count)
+	}
+	for _, picked := range usernames(GetUsersFromHeaders([]string{"Biographies"}, "", "request for comment", 5)) {
+		if picked == "Example one" {
+			t.Error("Example one was picked at the end of May, despite being at their limit")
+		}
+	}
+
+	populateTest(t, "2020-06-01T00:00:00Z", sentCounts)
+	user = GetSubscription("Example one", "Biographies")
+	if count := user.GetCount(); count != 0 || user.ExceedsLimit() {
+		t.Errorf("at the start of June, got count %d, want it reset to 0", count)
+	}
+	if archived := GetHistory()["2020-05"]["Biographies"]["Example one"]; archived != 2 {
+		t.Errorf("got %d archived for May, want 2", archived)
+	}
+	if picked := GetUsersFromHeaders([]string{"Biographies"}, "", "request for comment", 5); len(picked) != 5 {
+		t.Errorf("picked %q at the start of June, want all five users", usernames(picked))
+	}
+}
+
+func TestSentCountsAgeOutOfRollingWindow(t *testing.T) {
+	sentCounts := `"month":"2020-05","headers":{"Biographies":{"Example one":2}},` +
+		`"sent":{"Biographies":{"Example one":["2020-05-10T12:00:00Z","2020-05-20T12:00:00Z"]}}`
+
+	tests := []struct {
+		now  string
+		want uint16
+	}{
+		// the month has changed, but with a rolling window that doesn't reset anything
+		{"2020-06-01T00:00:00Z", 2},
+		{"2020-06-09T11:59:59Z", 2},
+		// thirty days after the first message, it drops out of the window
+		{"2020-06-09T12:00:00Z", 1},
+		{"2020-06-19T11:59:59Z", 1},
+		{"2020-06-19T12:00:00Z", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.now, func(t *testing.T) {
+			populateTest(t, test.now, sentCounts)
+			yapperconfig.Config.RollingWindowDays = 30
+			user := GetSubscription("Example one", "Biographies")
+			if count := user.GetCount(); count != test.want {
+				t.Errorf("got count %d, want %d", count, test.want)
+			}
+			if exceeds := user.ExceedsLimit(); exceeds != (test.want >= 2) {
+				t.Errorf("got ExceedsLimit %t with %d sent in the window", exceeds, test.want)
+			}
+		})
+	}
+}
+
+// lookupRecordingWiki is a FakeWiki that records every user whose status is looked up.
+type lookupRecordingWiki struct {
+	*wiki.FakeWiki
+	lookedUp []string
+}
+
+func (l *lookupRecordingWiki) UserStatuses(usernames []string) (map[string]wiki.UserStatus, error) {
+	l.lookedUp = append(l.lookedUp, usernames...)
+	return l.FakeWiki.UserStatuses(usernames)
+}
+
+func TestGetUsersFromHeadersOnlyLooksUpPickedUsers(t *testing.T) {
+	fake := &lookupRecordingWiki{FakeWiki: populateTest(t, "2020-06-15T12:00:00Z", `"month":"2020-06","headers":{}`)}
+	eligibility.Init(fake)
+
+	picked := usernames(GetUsersFromHeaders([]string{"Biographies"}, "", "request for comment", 2))
+	if !reflect.DeepEqual(fake.lookedUp, picked) {
+		t.Errorf("looked up %q, want only the users picked, %q", fake.lookedUp, picked)
+	}
+}
+
+func TestGetUsersFromHeadersPicksAgainForIneligibleUsers(t *testing.T) {
+	fake := &lookupRecordingWiki{FakeWiki: populateTest(t, "2020-06-15T12:00:00Z", `"month":"2020-06","headers":{}`)}
+	for _, username := range []string{"Example one", "Example two", "Example three", "Example four"} {
+		fake.Users[username] = wiki.FakeUser{Blocked: true}
+	}
+	eligibility.Init(fake)
+
+	if picked := usernames(GetUsersFromHeaders([]string{"Biographies"}, "", "request for comment", 1)); !reflect.DeepEqual(picked, []string{"Example five"}) {
+		t.Errorf("picked %q, want the only user who isn't blocked", picked)
+	}
+	seen := map[string]bool{}
+	for _, username := range fake.lookedUp {
+		if seen[username] {
+			t.Errorf("looked up %s more than once", username)
+		}
+		seen[username] = true
+	}
+}
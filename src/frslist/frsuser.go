@@ -18,6 +18,12 @@ package frslist
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 //
 
+import (
+	"sort"
+	"time"
+	"yapperbot-frs/src/clock"
+)
+
 // An FRSUser is a struct representing a user who has signed up for the FRS.
 // A single username may have multiple FRSUser objects; each corresponds to an
 // individual subscription.
@@ -26,20 +32,163 @@ type FRSUser struct {
 	Header   string
 	Limit    uint16
 	Limited  bool
+	// WeeklyLimit and DailyLimit are set by week= and day= on the subscription, and cap the
+	// number of messages for the header in any seven days and any 24 hours respectively.
+	// Zero means there's no cap.
+	WeeklyLimit uint16
+	DailyLimit  uint16
+	// Paused is set by paused=yes on the subscription. PausedUntil is set by until=,
+	// and is the start of the day after the date given, when the subscription resumes.
+	// If both are set, the subscription is only paused until PausedUntil.
+	Paused      bool
+	PausedUntil time.Time
+	// Digest is set by digest= on the subscription, to DigestDaily or DigestWeekly, if the user would
+	// rather have their messages for the header held and sent together. Otherwise, it's empty.
+	Digest string
 }
 
-// GetCount takes a header and gets the number of messages sent for that header this month.
+// GetCount takes a header and gets the number of messages sent for that header this month,
+// or over the rolling window, if one is configured.
 func (f FRSUser) GetCount() uint16 {
+	if window, rolling := rollingWindow(); rolling {
+		return f.GetCountSince(clock.Now().Add(-window))
+	}
+
 	sentCountMux.Lock()
 	defer sentCountMux.Unlock()
 	return sentCount[f.Header][f.Username]
 }
 
+// GetCountSince gets the number of messages sent for the user's header since the given time.
+func (f FRSUser) GetCountSince(since time.Time) (count uint16) {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+	for _, sent := range sentTimes[f.Header][f.Username] {
+		if sent.After(since) {
+			count++
+		}
+	}
+	return
+}
+
+// GetLastSent gets the last time the user was sent a message, about any header.
+// If they've never been sent one, it returns the zero time.
+func (f FRSUser) GetLastSent() time.Time {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+	return lastSent[f.Username]
+}
+
+// GetTotalCount gets the number of messages sent to the user this month, or over the rolling window,
+// if one is configured, across every header.
+func (f FRSUser) GetTotalCount() (total uint16) {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+
+	if window, rolling := rollingWindow(); rolling {
+		since := clock.Now().Add(-window)
+		for _, users := range sentTimes {
+			for _, sent := range users[f.Username] {
+				if sent.After(since) {
+					total++
+				}
+			}
+		}
+		return
+	}
+
+	for _, users := range sentCount {
+		total += users[f.Username]
+	}
+	return
+}
+
+// GetHistoricalTotal gets the number of messages sent to the user across every header
+// in the given number of most recent previous months that we have history for.
+func (f FRSUser) GetHistoricalTotal(months int) (total uint16) {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+
+	monthKeys := make([]string, 0, len(history))
+	for month := range history {
+		monthKeys = append(monthKeys, month)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(monthKeys)))
+	for i, month := range monthKeys {
+		if i >= months {
+			break
+		}
+		for _, users := range history[month] {
+			total += users[f.Username]
+		}
+	}
+	return
+}
+
+// GetTotalLimit returns the overall limit the user has set across all their subscriptions,
+// and whether they've set one at all.
+func (f FRSUser) GetTotalLimit() (limit uint16, limited bool) {
+	limit, limited = totalLimits[f.Username]
+	return
+}
+
+// IsPaused returns whether the user has paused this subscription for now.
+func (f FRSUser) IsPaused() bool {
+	if !f.PausedUntil.IsZero() {
+		return clock.Now().Before(f.PausedUntil)
+	}
+	return f.Paused
+}
+
+// GetHeldCount gets the number of messages for the user's header being held for their digest.
+func (f FRSUser) GetHeldCount() uint16 {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+	return held[f.Header][f.Username]
+}
+
+// GetTotalHeldCount gets the number of messages being held for the user's digests, across every header.
+func (f FRSUser) GetTotalHeldCount() (total uint16) {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+	for _, users := range held {
+		total += users[f.Username]
+	}
+	return
+}
+
 // ExceedsLimit is a simple helper function for checking if a user is limited,
 // and if they are, whether they can be messaged according to their limits.
+// This takes into account the limit on this subscription, its weekly and daily caps,
+// and the user's overall limit. Only messages that have been sent count; see ExceedsLimitWithHeld.
 func (f FRSUser) ExceedsLimit() bool {
-	if f.Limited {
-		return (f.GetCount() >= f.Limit)
+	return f.exceedsLimit(0, 0)
+}
+
+// ExceedsLimitWithHeld is ExceedsLimit, but it also counts the messages being held for the user's
+// digests, as though they'd been sent. It's used when picking users, so that a digest subscriber
+// can't be picked for more messages than they'll be able to receive; when a digest goes out,
+// it's checked against ExceedsLimit instead, as the messages in it are being sent.
+func (f FRSUser) ExceedsLimitWithHeld() bool {
+	return f.exceedsLimit(f.GetHeldCount(), f.GetTotalHeldCount())
+}
+
+// exceedsLimit takes the number of extra messages to count for the user's header and across all their
+// headers, on top of those that have been sent, and returns whether they're over any of their limits.
+// Extra messages count towards the weekly and daily caps too, as they'll be sent within them.
+func (f FRSUser) exceedsLimit(extra, totalExtra uint16) bool {
+	if f.Limited && f.GetCount()+extra >= f.Limit {
+		return true
+	}
+	now := clock.Now()
+	if f.WeeklyLimit > 0 && f.GetCountSince(now.Add(-week))+extra >= f.WeeklyLimit {
+		return true
+	}
+	if f.DailyLimit > 0 && f.GetCountSince(now.Add(-day))+extra >= f.DailyLimit {
+		return true
+	}
+	if totalLimit, totalLimited := f.GetTotalLimit(); totalLimited {
+		return f.GetTotalCount()+totalExtra >= totalLimit
 	}
 	return false
 }
@@ -55,7 +204,27 @@ func (f FRSUser) MarkMessageSent() {
 		sentCount[f.Header] = map[string]uint16{}
 	}
 
+	if sentTimes[f.Header] == nil {
+		sentTimes[f.Header] = map[string][]time.Time{}
+	}
+
+	now := clock.Now()
 	sentCount[f.Header][f.Username]++
+	sentTimes[f.Header][f.Username] = append(sentTimes[f.Header][f.Username], now)
+	lastSent[f.Username] = now
+}
+
+// MarkMessageHeld increases the number of messages being held for the user's digest by one. It's
+// used both for messages newly held this run, and for those still held from earlier runs.
+func (f FRSUser) MarkMessageHeld() {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+
+	// prevent nil map errors
+	if held[f.Header] == nil {
+		held[f.Header] = map[string]uint16{}
+	}
+	held[f.Header][f.Username]++
 }
 
 // MarkMessageUnsent decreases the number of messages sent for the user by one. It
@@ -70,4 +239,17 @@ func (f FRSUser) MarkMessageUnsent() {
 	}
 
 	sentCount[f.Header][f.Username]--
+
+	// the message being unsent is always the latest one for the header
+	if times := sentTimes[f.Header][f.Username]; len(times) > 0 {
+		sentTimes[f.Header][f.Username] = times[:len(times)-1]
+	}
+
+	// all of a user's messages are sent together, so if this one wasn't sent, none of them were;
+	// put their last sent time back to what it was before the run
+	if initial, ok := initialLastSent[f.Username]; ok {
+		lastSent[f.Username] = initial
+	} else {
+		delete(lastSent, f.Username)
+	}
 }
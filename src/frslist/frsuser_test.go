@@ -0,0 +1,148 @@
+//go:build fakewiki
+// +build fakewiki
+
+package frslist
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These are behind the fakewiki build tag because this package needs the ybtools config files
+// to load, so they're run by the e2e package, which provides them.
+
+import (
+	"testing"
+	"time"
+	"yapperbot-frs/src/clock"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// limitTestNow is when the clock is pinned for the limit tests.
+var limitTestNow = time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+
+// sentAgo takes how long ago each message was sent, relative to limitTestNow, and records them
+// as sent to Example user under Biographies, counting them in this month's sent count too.
+func sentAgo(ago ...time.Duration) {
+	reset()
+	sentTimes["Biographies"] = map[string][]time.Time{}
+	sentCount["Biographies"] = map[string]uint16{}
+	for _, d := range ago {
+		sentTimes["Biographies"]["Example user"] = append(sentTimes["Biographies"]["Example user"], limitTestNow.Add(-d))
+		sentCount["Biographies"]["Example user"]++
+	}
+}
+
+func TestExceedsLimitCaps(t *testing.T) {
+	tests := []struct {
+		name   string
+		weekly uint16
+		daily  uint16
+		ago    []time.Duration
+		held   uint16
+		want   bool
+	}{
+		{name: "no caps", ago: []time.Duration{time.Hour, 2 * time.Hour}},
+		{name: "under daily cap", daily: 2, ago: []time.Duration{time.Hour}},
+		{name: "at daily cap", daily: 2, ago: []time.Duration{time.Hour, 2 * time.Hour}, want: true},
+		{name: "daily cap just inside window", daily: 1, ago: []time.Duration{day - time.Second}, want: true},
+		{name: "daily cap at window edge", daily: 1, ago: []time.Duration{day}},
+		{name: "under weekly cap", weekly: 3, ago: []time.Duration{time.Hour, 3 * day}},
+		{name: "at weekly cap", weekly: 3, ago: []time.Duration{time.Hour, 3 * day, 6 * day}, want: true},
+		{name: "weekly cap just inside window", weekly: 1, ago: []time.Duration{week - time.Second}, want: true},
+		{name: "weekly cap at window edge", weekly: 1, ago: []time.Duration{week}},
+		{name: "weekly cap with older messages", weekly: 2, ago: []time.Duration{time.Hour, 8 * day, 20 * day}},
+		{name: "held counts towards daily cap", daily: 2, ago: []time.Duration{time.Hour}, held: 1, want: true},
+		{name: "held counts towards weekly cap", weekly: 2, ago: []time.Duration{3 * day}, held: 1, want: true},
+	}
+
+	clock.SetFixed(limitTestNow)
+	defer clock.Set(time.Now)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sentAgo(test.ago...)
+			user := FRSUser{Username: "Example user", Header: "Biographies", WeeklyLimit: test.weekly, DailyLimit: test.daily}
+			for i := uint16(0); i < test.held; i++ {
+				user.MarkMessageHeld()
+			}
+
+			if test.held == 0 {
+				if got := user.ExceedsLimit(); got != test.want {
+					t.Errorf("got ExceedsLimit %t, want %t", got, test.want)
+				}
+			} else {
+				if user.ExceedsLimit() {
+					t.Error("got ExceedsLimit true, want held messages not to count")
+				}
+				if got := user.ExceedsLimitWithHeld(); got != test.want {
+					t.Errorf("got ExceedsLimitWithHeld %t, want %t", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestExceedsLimitRollingWindow(t *testing.T) {
+	window := 30 * day
+	tests := []struct {
+		name  string
+		limit uint16
+		total uint16
+		ago   []time.Duration
+		// historyAgo are messages sent to the user under another header
+		historyAgo []time.Duration
+		want       bool
+	}{
+		{name: "under limit", limit: 2, ago: []time.Duration{time.Hour}},
+		{name: "at limit", limit: 2, ago: []time.Duration{time.Hour, 20 * day}, want: true},
+		{name: "just inside window", limit: 2, ago: []time.Duration{time.Hour, window - time.Second}, want: true},
+		{name: "at window edge", limit: 2, ago: []time.Duration{time.Hour, window}},
+		// these were sent this month as far as the monthly count goes, but it's not used with a rolling window
+		{name: "outside window", limit: 2, ago: []time.Duration{31 * day, 40 * day}},
+		{name: "under total", total: 3, ago: []time.Duration{time.Hour}, historyAgo: []time.Duration{2 * day, window}},
+		{name: "at total", total: 3, ago: []time.Duration{time.Hour}, historyAgo: []time.Duration{2 * day, window - time.Second}, want: true},
+	}
+
+	oldConfig := yapperconfig.Config
+	clock.SetFixed(limitTestNow)
+	defer func() {
+		yapperconfig.Config = oldConfig
+		clock.Set(time.Now)
+	}()
+	yapperconfig.Config.RollingWindowDays = 30
+	if period := LimitPeriod(); period != "in the last 30 days" {
+		t.Errorf("got limit period %q, want it to describe the rolling window", period)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sentAgo(test.ago...)
+			sentTimes["History"] = map[string][]time.Time{}
+			for _, d := range test.historyAgo {
+				sentTimes["History"]["Example user"] = append(sentTimes["History"]["Example user"], limitTestNow.Add(-d))
+			}
+			if test.total > 0 {
+				totalLimits["Example user"] = test.total
+			}
+
+			user := FRSUser{Username: "Example user", Header: "Biographies", Limit: test.limit, Limited: test.limit > 0}
+			if got := user.ExceedsLimit(); got != test.want {
+				t.Errorf("got ExceedsLimit %t with %d sent under the header and %d overall in the window, want %t",
+					got, user.GetCount(), user.GetTotalCount(), test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package frslist
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+
+	"cgt.name/pkg/go-mwclient"
+)
+
+// A LintIssue is a single problem found on the FRS list by Lint.
+type LintIssue struct {
+	// Line is the line number the problem is on, counting from 1.
+	Line int
+	// Header is the header the problem is under, if any.
+	Header  string
+	Problem string
+	// Text is the text of the line the problem is on.
+	Text string
+}
+
+// lintReportEditSummary is the edit summary used when saving the lint report.
+const lintReportEditSummary string = "Updating the FRS list lint report"
+
+// lintFixEditSummary is the edit summary used when normalising the formatting of the FRS list.
+const lintFixEditSummary string = "Normalising the formatting of the [[WP:FRS|Feedback Request Service]] list; see [[WP:FRS]] for the expected format"
+
+// lintHeaderRegex matches a subsection header on the FRS list, which is what users subscribe under.
+var lintHeaderRegex *regexp.Regexp
+
+// lintSectionRegex matches any other section header on the FRS list.
+var lintSectionRegex *regexp.Regexp
+
+// lintMentionRegex matches any mention of the frs user template, however it's formatted.
+var lintMentionRegex *regexp.Regexp
+
+// lintLooseUserRegex matches a frs user template with a second parameter that isn't a valid limit,
+// so that we can tell users with a mistyped limit apart from lines we can't parse at all.
+var lintLooseUserRegex *regexp.Regexp
+
+// lintEntryPrefixRegex matches everything up to the username in a frs user template on a line,
+// however it's formatted, so that it can be normalised.
+var lintEntryPrefixRegex *regexp.Regexp
+
+// lintCommentRegex matches HTML comments, which can span multiple lines.
+var lintCommentRegex *regexp.Regexp
+
+func init() {
+	lintHeaderRegex = regexp.MustCompile(`^===([^=].*?)===\s*$`)
+	lintSectionRegex = regexp.MustCompile(`^==.*==\s*$`)
+	lintMentionRegex = regexp.MustCompile(`(?i){{\s*frs user`)
+	lintLooseUserRegex = regexp.MustCompile(`(?i){{frs user\|([^|}]*)\|([^|}=]*)(?:\||}})`)
+	lintEntryPrefixRegex = regexp.MustCompile(`(?i)^\s*\*?\s*{{\s*frs user\s*\|\s*`)
+	lintCommentRegex = regexp.MustCompile(`(?s)<!--.*?-->`)
+}
+
+// Lint fetches the FRS list, checks it for problems, and saves a report of what it found to the
+// configured lint report page. knownHeader is called with each header on the list, and should
+// return whether any request could ever match it. If the lintautofix config is set, it also
+// normalises the formatting of the list, so that entries the parser would otherwise drop are
+// picked up; if anyone edits the list in the meantime, the fix is left for the next run, rather
+// than overwriting their edit. Lint does nothing if there's no lint report page configured.
+func Lint(w wiki.Wiki, knownHeader func(header string) bool) {
+	if yapperconfig.Config.LintReportPageID == "" {
+		return
+	}
+
+	revision, err := w.FetchRevision(yapperconfig.Config.FRSPageID)
+	if err != nil {
+		log.Println("Failed to fetch FRS page for linting, so skipping the lint. The error was", err)
+		return
+	}
+	text := revision.Content
+
+	issues := lintList(text, knownHeader)
+	log.Println("Linted the FRS list and found", len(issues), "problems")
+	if w.CanEdit() {
+		saveLintPage(w, yapperconfig.Config.LintReportPageID, lintReportEditSummary, lintReport(issues))
+	}
+
+	if yapperconfig.Config.LintAutoFix {
+		if normalised := normaliseList(text); normalised != text && w.CanEdit() {
+			saveLintFix(w, revision, normalised)
+		}
+	}
+}
+
+// saveLintFix saves the normalised FRS list over the revision of it that was linted. If the list has
+// been edited or deleted since, the fix is skipped; the next run will lint the list as it is then.
+func saveLintFix(w wiki.Wiki, revision wiki.Revision, normalised string) {
+	err := w.EditRevision(revision, lintFixEditSummary, normalised)
+	if apiErr, ok := err.(mwclient.APIError); ok && (apiErr.Code == "editconflict" || apiErr.Code == "pagedeleted") {
+		log.Println("The FRS list changed while it was being linted, so leaving its formatting to be fixed next run")
+		return
+	}
+	logLintSave(yapperconfig.Config.FRSPageID, err)
+}
+
+// saveLintPage saves text to a page for Lint. Linting isn't critical to the run,
+// so failures are only logged.
+func saveLintPage(w wiki.Wiki, pageID, summary, text string) {
+	logLintSave(pageID, w.EditPage(pageID, summary, text))
+}
+
+// logLintSave takes the ID of a page Lint saved to, and the error from saving it, and logs how it went.
+func logLintSave(pageID string, err error) {
+	switch {
+	case err == nil:
+		log.Println("Successfully saved lint changes to page ID", pageID)
+	case err.Error() == "edit successful, but did not change page":
+		log.Println("Lint changes to page ID", pageID, "didn't change anything")
+	default:
+		log.Println("Failed to save lint changes to page ID", pageID, "with error", err)
+	}
+}
+
+// lintList takes the wikitext of the FRS list, and returns every problem found in it. It reports
+// subscriptions that the list parser drops, ones it can't parse, duplicate subscriptions for the same
+// user under the same header, invalid limits, headers with nobody subscribed, and headers that
+// knownHeader says won't ever match a request.
+func lintList(text string, knownHeader func(header string) bool) (issues []LintIssue) {
+	// parsedSpans are the parts of the text that populateFrsList reads users out of
+	var parsedSpans [][]int
+	for _, match := range listParserRegex.FindAllStringSubmatchIndex(text, -1) {
+		parsedSpans = append(parsedSpans, []int{match[4], match[5]})
+	}
+	commentSpans := lintCommentRegex.FindAllStringIndex(text, -1)
+
+	var header string
+	var headers []string
+	headerLines := map[string]int{}
+	headerCounts := map[string]int{}
+	// seen maps headers down to users, and then users down to the line they were first seen on
+	seen := map[string]map[string]int{}
+
+	offset := 0
+	for index, line := range strings.Split(text, "\n") {
+		lineNumber := index + 1
+		lineStart := offset
+		offset += len(line) + 1
+
+		if match := lintHeaderRegex.FindStringSubmatch(line); match != nil {
+			header = match[1]
+			if _, exists := headerLines[header]; !exists {
+				headers = append(headers, header)
+				headerLines[header] = lineNumber
+				seen[header] = map[string]int{}
+			}
+			continue
+		} else if lintSectionRegex.MatchString(line) {
+			header = ""
+			continue
+		}
+
+		mention := lintMentionRegex.FindStringIndex(line)
+		if mention == nil {
+			continue
+		}
+
+		issue := LintIssue{Line: lineNumber, Header: header, Text: line}
+		switch {
+		case inSpans(lineStart+mention[0], commentSpans):
+			issue.Problem = "this subscription is inside an HTML comment, so it's ignored"
+		case !inSpans(lineStart+mention[0], parsedSpans):
+			switch {
+			case !strings.HasPrefix(strings.TrimSpace(line), "*"):
+				issue.Problem = "this subscription doesn't start with a *, so it's ignored"
+			case header == "":
+				issue.Problem = "this subscription isn't under a === subsection header ===, so it's ignored"
+			case lineStart > 0 && parsedSpans != nil && parsedSpans[0][1] <= lineStart:
+				issue.Problem = fmt.Sprintf("this subscription is cut off from the list above it by something on line %d, so it's ignored", brokenAt(text, parsedSpans, lineStart))
+			default:
+				issue.Problem = "this subscription isn't in the format the list expects, so it's ignored"
+			}
+		default:
+			issue.Problem = lintEntry(line, header, lineNumber, seen[header])
+			if issue.Problem == "" {
+				headerCounts[header]++
+			}
+		}
+		if issue.Problem != "" {
+			issues = append(issues, issue)
+		}
+	}
+
+	for _, header := range headers {
+		if headerCounts[header] == 0 {
+			issues = append(issues, LintIssue{Line: headerLines[header], Header: header, Problem: "nobody is subscribed under this header"})
+		}
+		if !knownHeader(header) {
+			issues = append(issues, LintIssue{Line: headerLines[header], Header: header, Problem: "this header doesn't match any RfC category, GA topic or other request type, so nobody under it will ever be messaged"})
+		}
+	}
+	return
+}
+
+// lintEntry takes a line which the list parser reads a user out of, the header it's under, its line
+// number, and the users already seen under the header mapped to the line they were first seen on.
+// It returns the problem with the entry, or empty string if there isn't one.
+func lintEntry(line, header string, lineNumber int, seenInHeader map[string]int) string {
+	usermatched := userParserRegex.FindStringSubmatch(line)
+	if usermatched == nil {
+		if loose := lintLooseUserRegex.FindStringSubmatch(line); loose != nil {
+			return fmt.Sprintf("the limit %q isn't a number, so this subscription is ignored", strings.TrimSpace(loose[2]))
+		}
+		return "this subscription couldn't be parsed, so it's ignored"
+	}
+
+	// usermatched is [entire match, user name, requested limit, named params]
+	if usermatched[2] != "" {
+		if _, err := strconv.ParseInt(usermatched[2], 10, 16); err != nil {
+			return fmt.Sprintf("the limit %s is too large, so this subscription is ignored", usermatched[2])
+		}
+	}
+	namedParams := parseNamedParams(usermatched[3])
+	if total, ok := namedParams["total"]; ok {
+		if _, err := strconv.ParseUint(total, 10, 16); err != nil {
+			return fmt.Sprintf("the overall limit %q isn't valid, so it's ignored", total)
+		}
+	}
+
+	for _, param := range []string{"week", "day"} {
+		if value, ok := namedParams[param]; ok {
+			if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+				return fmt.Sprintf("the %s cap %q isn't valid, so it's ignored", param, value)
+			}
+		}
+	}
+
+	if delivery, ok := namedParams["delivery"]; ok {
+		if lower := strings.ToLower(delivery); lower != DeliveryTalkPage && lower != DeliveryEmail {
+			return fmt.Sprintf("the delivery %q should be %s or %s, so it's ignored", delivery, DeliveryTalkPage, DeliveryEmail)
+		}
+	}
+
+	if digest, ok := namedParams["digest"]; ok {
+		if lower := strings.ToLower(digest); lower != DigestDaily && lower != DigestWeekly {
+			return fmt.Sprintf("the digest %q should be %s or %s, so it's ignored", digest, DigestDaily, DigestWeekly)
+		}
+	}
+
+	if until, ok := namedParams["until"]; ok {
+		if _, err := parseUntil(until); err != nil {
+			return fmt.Sprintf("the until date %q isn't in the form YYYY-MM-DD, so it's ignored", until)
+		}
+	}
+
+	username := normaliseUsername(usermatched[1])
+	if firstLine, duplicate := seenInHeader[username]; duplicate {
+		return fmt.Sprintf("%s is already subscribed under this header on line %d", username, firstLine)
+	}
+	seenInHeader[username] = lineNumber
+	return ""
+}
+
+// normaliseUsername takes a username as written on the FRS list, and returns it the way
+// MediaWiki would, so that differently-written versions of the same name compare equal.
+func normaliseUsername(username string) string {
+	username = strings.TrimSpace(strings.ReplaceAll(username, "_", " "))
+	if username == "" {
+		return username
+	}
+	return strings.ToUpper(username[:1]) + username[1:]
+}
+
+// brokenAt takes the text of the FRS list, the spans the list parser read, and the offset of a line
+// the parser didn't read. It returns the line number of the last line the parser read before it,
+// which is where whatever cut the list off is.
+func brokenAt(text string, parsedSpans [][]int, offset int) int {
+	var lastEnd int
+	for _, span := range parsedSpans {
+		if span[1] <= offset && span[1] > lastEnd {
+			lastEnd = span[1]
+		}
+	}
+	return strings.Count(strings.TrimRight(text[:lastEnd], "\n"), "\n") + 1
+}
+
+// inSpans returns whether the offset is within any of the [start, end) spans.
+func inSpans(offset int, spans [][]int) bool {
+	for _, span := range spans {
+		if offset >= span[0] && offset < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// lintReport takes the issues found by lintList, and returns the wikitext of the lint report page.
+func lintReport(issues []LintIssue) string {
+	var reportBuilder strings.Builder
+	reportBuilder.WriteString("This page lists problems found on [[WP:FRS]] that stop subscriptions from working as expected. ")
+	reportBuilder.WriteString("It's updated automatically each time the Feedback Request Service runs; last updated ~~~~~.\n\n")
+
+	if len(issues) == 0 {
+		reportBuilder.WriteString("No problems found.\n")
+		return reportBuilder.String()
+	}
+
+	reportBuilder.WriteString("{| class=\"wikitable sortable\"\n! Line !! Header !! Problem !! Text\n")
+	for _, issue := range issues {
+		reportBuilder.WriteString(fmt.Sprintf("|-\n| %d || %s || %s || %s\n",
+			issue.Line, nowiki(lintCommentRegex.ReplaceAllString(issue.Header, "")), issue.Problem, nowiki(issue.Text)))
+	}
+	reportBuilder.WriteString("|}\n")
+	return reportBuilder.String()
+}
+
+// nowiki wraps text in nowiki tags, so it's shown as written, or returns empty string for no text.
+func nowiki(text string) string {
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	return "<code><nowiki>" + strings.ReplaceAll(text, "</nowiki>", "&lt;/nowiki>") + "</nowiki></code>"
+}
+
+// normaliseList takes the wikitext of the FRS list, and returns it with the formatting of each
+// subscription normalised to *{{frs user|...}}, trailing whitespace removed from subscriptions,
+// and blank lines between subscriptions removed. Subscriptions inside HTML comments are left alone.
+func normaliseList(text string) string {
+	commentSpans := lintCommentRegex.FindAllStringIndex(text, -1)
+	lines := strings.Split(text, "\n")
+	isEntry := make([]bool, len(lines))
+
+	offset := 0
+	for index, line := range lines {
+		lineStart := offset
+		offset += len(line) + 1
+
+		mention := lintMentionRegex.FindStringIndex(line)
+		if mention == nil || inSpans(lineStart+mention[0], commentSpans) {
+			continue
+		}
+		if prefix := lintEntryPrefixRegex.FindString(line); prefix != "" {
+			lines[index] = "*{{frs user|" + strings.TrimRight(line[len(prefix):], " \t")
+			isEntry[index] = true
+		}
+	}
+
+	var normalised []string
+	for index, line := range lines {
+		if strings.TrimSpace(line) == "" && betweenEntries(index, lines, isEntry) {
+			continue
+		}
+		normalised = append(normalised, line)
+	}
+	return strings.Join(normalised, "\n")
+}
+
+// betweenEntries returns whether the blank line at index has subscriptions directly either side of it,
+// ignoring any other blank lines.
+func betweenEntries(index int, lines []string, isEntry []bool) bool {
+	before, after := false, false
+	for i := index - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			before = isEntry[i]
+			break
+		}
+	}
+	for i := index + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			after = isEntry[i]
+			break
+		}
+	}
+	return before && after
+}
@@ -0,0 +1,272 @@
+//go:build fakewiki
+// +build fakewiki
+
+package frslist
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These are behind the fakewiki build tag because this package needs the ybtools config files
+// to load, so they're run by the e2e package, which provides them.
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// lintTestList is the start of a list with one valid subscription, which the lines under test follow.
+const lintTestList string = "===Biographies===\n*{{frs user|Example valid|1}}\n"
+
+func TestLintList(t *testing.T) {
+	// wantIssue is a problem expected from lintList: the line it's on, and part of the problem text
+	type wantIssue struct {
+		line    int
+		problem string
+	}
+	tests := []struct {
+		name string
+		text string
+		want []wantIssue
+	}{
+		{
+			name: "valid",
+			text: lintTestList + "*{{frs user|Example other|2|total=5|week=2|day=1|delivery=email|digest=daily|until=2030-01-01}}\n",
+		},
+		{
+			name: "commented out",
+			text: lintTestList + "<!--*{{frs user|Example hidden|1}}-->\n",
+			want: []wantIssue{{3, "inside an HTML comment"}},
+		},
+		{
+			name: "no star",
+			text: lintTestList + "{{frs user|Example starless|1}}\n",
+			want: []wantIssue{{3, "doesn't start with a *"}},
+		},
+		{
+			name: "no subsection header",
+			text: "== Subscriptions ==\n*{{frs user|Example headless|1}}\n",
+			want: []wantIssue{{2, "isn't under a === subsection header ==="}},
+		},
+		{
+			name: "cut off",
+			text: lintTestList + "Some stray text\n*{{frs user|Example cut off|1}}\n",
+			want: []wantIssue{{4, "cut off from the list above it by something on line 2"}},
+		},
+		{
+			name: "unexpected format",
+			text: "===Biographies===\n* {{ frs user|Example spaced|1}}\n",
+			want: []wantIssue{{2, "isn't in the format the list expects"}, {1, "nobody is subscribed"}},
+		},
+		{
+			name: "unparseable",
+			text: lintTestList + "*{{frs user}}\n",
+			want: []wantIssue{{3, "couldn't be parsed"}},
+		},
+		{
+			name: "limit not a number",
+			text: lintTestList + "*{{frs user|Example wordy|lots}}\n",
+			want: []wantIssue{{3, `the limit "lots" isn't a number`}},
+		},
+		{
+			name: "limit too large",
+			text: lintTestList + "*{{frs user|Example greedy|99999}}\n",
+			want: []wantIssue{{3, "the limit 99999 is too large"}},
+		},
+		{
+			name: "invalid total",
+			text: lintTestList + "*{{frs user|Example total|1|total=x}}\n",
+			want: []wantIssue{{3, `the overall limit "x" isn't valid`}},
+		},
+		{
+			name: "invalid week cap",
+			text: lintTestList + "*{{frs user|Example weekly|1|week=-1}}\n",
+			want: []wantIssue{{3, `the week cap "-1" isn't valid`}},
+		},
+		{
+			name: "invalid day cap",
+			text: lintTestList + "*{{frs user|Example daily|1|day=one}}\n",
+			want: []wantIssue{{3, `the day cap "one" isn't valid`}},
+		},
+		{
+			name: "invalid delivery",
+			text: lintTestList + "*{{frs user|Example pigeon|1|delivery=pigeon}}\n",
+			want: []wantIssue{{3, `the delivery "pigeon" should be`}},
+		},
+		{
+			name: "invalid digest",
+			text: lintTestList + "*{{frs user|Example hourly|1|digest=hourly}}\n",
+			want: []wantIssue{{3, `the digest "hourly" should be`}},
+		},
+		{
+			name: "invalid until",
+			text: lintTestList + "*{{frs user|Example soon|1|until=soon}}\n",
+			want: []wantIssue{{3, `the until date "soon" isn't in the form YYYY-MM-DD`}},
+		},
+		{
+			name: "duplicate",
+			text: lintTestList + "*{{frs user|example_valid|2}}\n",
+			want: []wantIssue{{3, "Example valid is already subscribed under this header on line 2"}},
+		},
+		{
+			name: "empty header",
+			text: "===Biographies===\nNobody here yet\n",
+			want: []wantIssue{{1, "nobody is subscribed under this header"}},
+		},
+		{
+			name: "unknown header",
+			text: "===Nonsense===\n*{{frs user|Example valid|1}}\n",
+			want: []wantIssue{{1, "doesn't match any RfC category"}},
+		},
+	}
+
+	knownHeader := func(header string) bool { return header == "Biographies" }
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issues := lintList(test.text, knownHeader)
+			if len(issues) != len(test.want) {
+				t.Fatalf("got %d issues %+v, want %d", len(issues), issues, len(test.want))
+			}
+			for i, want := range test.want {
+				if issues[i].Line != want.line || !strings.Contains(issues[i].Problem, want.problem) {
+					t.Errorf("got issue %+v, want line %d with a problem containing %q", issues[i], want.line, want.problem)
+				}
+			}
+		})
+	}
+}
+
+// parsedUsers takes the text of the FRS list, and returns the users the list parser reads out of it.
+func parsedUsers(text string) (users []string) {
+	for _, match := range listParserRegex.FindAllStringSubmatch(text, -1) {
+		for _, usermatched := range userParserRegex.FindAllStringSubmatch(match[2], -1) {
+			users = append(users, usermatched[1])
+		}
+	}
+	return
+}
+
+func TestNormaliseList(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+		// wantUsers are the users the list parser should read out of the normalised list
+		wantUsers []string
+	}{
+		{
+			name:      "already normal",
+			text:      "===Biographies===\n*{{frs user|Example one|1}}\n*{{frs user|Example two|2}}\n",
+			want:      "===Biographies===\n*{{frs user|Example one|1}}\n*{{frs user|Example two|2}}\n",
+			wantUsers: []string{"Example one", "Example two"},
+		},
+		{
+			name:      "spacing",
+			text:      "===Biographies===\n * {{ frs user | Example one|1}}  \n",
+			want:      "===Biographies===\n*{{frs user|Example one|1}}\n",
+			wantUsers: []string{"Example one"},
+		},
+		{
+			name:      "no star",
+			text:      "===Biographies===\n*{{frs user|Example one|1}}\n{{Frs user|Example two|2}}\n",
+			want:      "===Biographies===\n*{{frs user|Example one|1}}\n*{{frs user|Example two|2}}\n",
+			wantUsers: []string{"Example one", "Example two"},
+		},
+		{
+			name:      "cut off by indented entry",
+			text:      "===Biographies===\n*{{frs user|Example one|1}}\n  *{{frs user|Example two|2}}\n*{{frs user|Example three|3}}\n",
+			want:      "===Biographies===\n*{{frs user|Example one|1}}\n*{{frs user|Example two|2}}\n*{{frs user|Example three|3}}\n",
+			wantUsers: []string{"Example one", "Example two", "Example three"},
+		},
+		{
+			name:      "blank lines",
+			text:      "===Biographies===\n*{{frs user|Example one|1}}\n\n \n*{{frs user|Example two|2}}\n\n===Geography===\n",
+			want:      "===Biographies===\n*{{frs user|Example one|1}}\n*{{frs user|Example two|2}}\n\n===Geography===\n",
+			wantUsers: []string{"Example one", "Example two"},
+		},
+		{
+			name:      "commented out",
+			text:      "===Biographies===\n*{{frs user|Example one|1}}\n<!--\n * {{frs user|Example hidden|1}}\n-->\n",
+			want:      "===Biographies===\n*{{frs user|Example one|1}}\n<!--\n * {{frs user|Example hidden|1}}\n-->\n",
+			wantUsers: []string{"Example one"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			normalised := normaliseList(test.text)
+			if normalised != test.want {
+				t.Errorf("got %q, want %q", normalised, test.want)
+			}
+			if users := parsedUsers(normalised); !reflect.DeepEqual(users, test.wantUsers) {
+				t.Errorf("parsed users %q from the normalised list, want %q", users, test.wantUsers)
+			}
+		})
+	}
+}
+
+// conflictingWiki is a FakeWiki where someone else edits every page as soon as the bot fetches it.
+type conflictingWiki struct {
+	*wiki.FakeWiki
+}
+
+func (c conflictingWiki) FetchRevision(pageID string) (wiki.Revision, error) {
+	revision, err := c.FakeWiki.FetchRevision(pageID)
+	if err == nil {
+		err = c.FakeWiki.EditPage(pageID, "Someone else's edit", revision.Content+"*{{frs user|Example newcomer|1}}\n")
+	}
+	return revision, err
+}
+
+// lintTestWiki sets up the config for Lint to autofix, and returns a FakeWiki holding text as the FRS list.
+func lintTestWiki(t *testing.T, text string) *wiki.FakeWiki {
+	oldConfig := yapperconfig.Config
+	t.Cleanup(func() { yapperconfig.Config = oldConfig })
+	yapperconfig.Config.FRSPageID = "1"
+	yapperconfig.Config.LintReportPageID = "2"
+	yapperconfig.Config.LintAutoFix = true
+
+	fake := wiki.NewFakeWiki()
+	fake.AddPage(wiki.FakePage{ID: "1", Title: "Wikipedia:Feedback request service", Content: text})
+	fake.AddPage(wiki.FakePage{ID: "2", Title: "Wikipedia:Feedback request service/Lint"})
+	return fake
+}
+
+func TestLintAutoFix(t *testing.T) {
+	fake := lintTestWiki(t, "===Biographies===\n {{frs user|Example one|1}}\n")
+	Lint(fake, func(string) bool { return true })
+
+	if content := fake.Page("Wikipedia:Feedback request service").Content; content != "===Biographies===\n*{{frs user|Example one|1}}\n" {
+		t.Errorf("got FRS list %q, want it normalised", content)
+	}
+}
+
+func TestLintAutoFixSkipsEditConflicts(t *testing.T) {
+	fake := lintTestWiki(t, "===Biographies===\n {{frs user|Example one|1}}\n")
+	Lint(conflictingWiki{fake}, func(string) bool { return true })
+
+	want := "===Biographies===\n {{frs user|Example one|1}}\n*{{frs user|Example newcomer|1}}\n"
+	if content := fake.Page("Wikipedia:Feedback request service").Content; content != want {
+		t.Errorf("got FRS list %q, want the conflicting edit kept as it was", content)
+	}
+	if report := fake.Page("Wikipedia:Feedback request service/Lint").Content; !strings.Contains(report, "doesn't start with a *") {
+		t.Errorf("got lint report %q, want it to still report the problem", report)
+	}
+}
@@ -0,0 +1,101 @@
+package frslist
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"log"
+	"yapperbot-frs/src/random"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// A SelectionStrategy decides which users get sent a message about a request.
+type SelectionStrategy interface {
+	// Select takes the candidate subscriptions for a request - all of which are under their limits -
+	// along with the request's all header, if any, and returns up to n of them. It mustn't return
+	// more than one subscription for the same user. A user subscribed to more than one of the
+	// request's headers appears once in candidates for each header.
+	Select(candidates []*FRSUser, allHeader string, n int) []*FRSUser
+}
+
+// defaultStrategyName is the strategy used when nothing else is configured.
+const defaultStrategyName string = "weighted"
+
+// selectionStrategies maps the names used in the config to each SelectionStrategy.
+var selectionStrategies = map[string]SelectionStrategy{
+	"weighted":    weightedStrategy{},
+	"leastrecent": leastRecentStrategy{},
+	"roundrobin":  roundRobinStrategy{},
+	"uniform":     uniformStrategy{},
+}
+
+// strategyFor takes the headers and type of a request, and returns the SelectionStrategy to use for it.
+// The selectionstrategies config maps header names and request types to strategy names. If all the
+// headers with a strategy configured agree on it, that's used; otherwise, the strategy for the request
+// type is used, and failing that, the configured default, and failing that, weightedStrategy.
+func strategyFor(headers []string, requestType string) SelectionStrategy {
+	var headerStrategy string
+	for _, header := range headers {
+		if name, ok := yapperconfig.Config.SelectionStrategies[header]; ok {
+			if headerStrategy != "" && headerStrategy != name {
+				// the headers disagree, so we can't use either of them
+				headerStrategy = ""
+				break
+			}
+			headerStrategy = name
+		}
+	}
+
+	for _, name := range []string{headerStrategy, yapperconfig.Config.SelectionStrategies[requestType], yapperconfig.Config.DefaultSelectionStrategy} {
+		if name == "" {
+			continue
+		}
+		if strategy, ok := selectionStrategies[name]; ok {
+			return strategy
+		}
+		log.Println("WARNING: Unknown selection strategy", name, "in config, so ignoring it")
+	}
+	return selectionStrategies[defaultStrategyName]
+}
+
+// pickUnique takes an ordered list of subscriptions, and returns the first n of them,
+// skipping any for users who've already been picked.
+func pickUnique(ordered []*FRSUser, n int) []*FRSUser {
+	returnedUsers := make([]*FRSUser, 0, n)
+	usersSelected := map[string]bool{}
+	for _, user := range ordered {
+		if len(returnedUsers) >= n {
+			break
+		}
+		if !usersSelected[user.Username] {
+			returnedUsers = append(returnedUsers, user)
+			usersSelected[user.Username] = true
+		}
+	}
+	return returnedUsers
+}
+
+// shuffled returns a shuffled copy of a list of subscriptions, leaving the original alone.
+func shuffled(users []*FRSUser) []*FRSUser {
+	shuffledUsers := make([]*FRSUser, len(users))
+	copy(shuffledUsers, users)
+	random.Shuffle(len(shuffledUsers), func(i, j int) {
+		shuffledUsers[i], shuffledUsers[j] = shuffledUsers[j], shuffledUsers[i]
+	})
+	return shuffledUsers
+}
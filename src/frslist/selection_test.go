@@ -0,0 +1,218 @@
+//go:build fakewiki
+// +build fakewiki
+
+package frslist
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These are behind the fakewiki build tag because this package needs the ybtools config files
+// to load, so they're run by the e2e package, which provides them.
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"yapperbot-frs/src/random"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// selectionTrials is how many times the random strategies are run when checking how their picks are spread.
+const selectionTrials int = 2000
+
+// testUser takes a username, header, limit and the number of messages they've been sent this month,
+// and returns a subscription for them, recording the count in sentCount. A limit of 0 is unlimited.
+func testUser(username, header string, limit, sent uint16) *FRSUser {
+	if sentCount[header] == nil {
+		sentCount[header] = map[string]uint16{}
+	}
+	sentCount[header][username] = sent
+	return &FRSUser{Username: username, Header: header, Limit: limit, Limited: limit > 0}
+}
+
+// countPicks runs a strategy selectionTrials times from a fixed seed, and returns how often each user was picked.
+func countPicks(t *testing.T, strategy SelectionStrategy, candidates []*FRSUser, allHeader string, n int) map[string]int {
+	random.Seed(1)
+	picks := map[string]int{}
+	for trial := 0; trial < selectionTrials; trial++ {
+		selected := strategy.Select(candidates, allHeader, n)
+		if len(selected) != n {
+			t.Fatalf("picked %d users, want %d", len(selected), n)
+		}
+		seen := map[string]bool{}
+		for _, user := range selected {
+			if seen[user.Username] {
+				t.Fatalf("picked %s twice in %q", user.Username, usernames(selected))
+			}
+			seen[user.Username] = true
+			picks[user.Username]++
+		}
+	}
+	return picks
+}
+
+func TestWeightedStrategy(t *testing.T) {
+	reset()
+	candidates := []*FRSUser{
+		testUser("Example fresh", "Biographies", 10, 0),
+		testUser("Example halfway", "Biographies", 10, 5),
+		testUser("Example busy", "Biographies", 10, 9),
+		testUser("Example generalist", "All RfCs", 10, 0),
+		testUser("Example unlimited", "Biographies", 0, 20),
+	}
+	picks := countPicks(t, weightedStrategy{}, candidates, "All RfCs", 1)
+
+	// the weights are one more than how far through their limit each user is, so 1, 1.5 and 1.9,
+	// and 1 for the generalist, doubled to 2 as they're under the all header. The unlimited user gets
+	// the median of the weights before doubling, (1 + 1.5) / 2. Each user's chance of being picked
+	// is proportional to the reciprocal of their weight.
+	want := map[string]float64{
+		"Example fresh":      1 / 1.0,
+		"Example halfway":    1 / 1.5,
+		"Example busy":       1 / 1.9,
+		"Example generalist": 1 / 2.0,
+		"Example unlimited":  1 / 1.25,
+	}
+	var total float64
+	for _, chance := range want {
+		total += chance
+	}
+	for username, chance := range want {
+		share := float64(picks[username]) / float64(selectionTrials)
+		if wantShare := chance / total; share < wantShare-0.02 || share > wantShare+0.02 {
+			t.Errorf("%s was picked %.3f of the time, want about %.3f", username, share, wantShare)
+		}
+	}
+}
+
+func TestLeastRecentStrategy(t *testing.T) {
+	reset()
+	candidates := []*FRSUser{
+		testUser("Example recent", "Biographies", 0, 0),
+		testUser("Example never", "Biographies", 0, 0),
+		testUser("Example old", "Biographies", 0, 0),
+		testUser("Example old", "History", 0, 0),
+	}
+	lastSent["Example recent"] = time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	lastSent["Example old"] = time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	for seed := int64(0); seed < 5; seed++ {
+		random.Seed(seed)
+		got := usernames(leastRecentStrategy{}.Select(candidates, "", 3))
+		if want := []string{"Example never", "Example old", "Example recent"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("with seed %d, got %q, want %q", seed, got, want)
+		}
+	}
+}
+
+func TestRoundRobinStrategy(t *testing.T) {
+	reset()
+	candidates := []*FRSUser{
+		testUser("Example roomy", "Biographies", 5, 0),
+		testUser("Example cramped", "Biographies", 5, 4),
+		testUser("Example middling", "Biographies", 5, 2),
+		testUser("Example generalist", "All RfCs", 0, 0),
+		testUser("Example other generalist", "All RfCs", 0, 0),
+		testUser("Example historian", "History", 5, 0),
+	}
+
+	for seed := int64(0); seed < 5; seed++ {
+		random.Seed(seed)
+		perHeader := map[string][]string{}
+		for _, user := range (roundRobinStrategy{}).Select(candidates, "All RfCs", 5) {
+			perHeader[user.Header] = append(perHeader[user.Header], user.Username)
+		}
+
+		// over three rounds, Biographies gets three turns, History one before it runs out,
+		// and the all header a turn every other round
+		if want := []string{"Example roomy", "Example middling", "Example cramped"}; !reflect.DeepEqual(perHeader["Biographies"], want) {
+			t.Errorf("with seed %d, got %q from Biographies, want the most room left first, %q", seed, perHeader["Biographies"], want)
+		}
+		if len(perHeader["History"]) != 1 || len(perHeader["All RfCs"]) != 1 {
+			t.Errorf("with seed %d, got %v, want one each from History and All RfCs", seed, perHeader)
+		}
+	}
+}
+
+func TestUniformStrategy(t *testing.T) {
+	reset()
+	candidates := []*FRSUser{
+		testUser("Example fresh", "Biographies", 10, 0),
+		testUser("Example busy", "Biographies", 10, 9),
+		testUser("Example generalist", "All RfCs", 10, 0),
+		testUser("Example generalist", "Biographies", 10, 0),
+	}
+	picks := countPicks(t, uniformStrategy{}, candidates, "All RfCs", 2)
+
+	// the generalist has two subscriptions, so is more likely to be picked, but only once each time
+	if picks["Example generalist"] <= picks["Example fresh"] || picks["Example fresh"] < selectionTrials*2/5 || picks["Example busy"] < selectionTrials*2/5 {
+		t.Errorf("got picks %v, want them spread regardless of messages sent", picks)
+	}
+}
+
+func TestCalculateMedian(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []float64
+		want    float64
+		wantOK  bool
+	}{
+		{"empty", nil, 0, false},
+		{"one", []float64{1.5}, 1.5, true},
+		{"odd", []float64{3, 1, 2}, 2, true},
+		{"even", []float64{4, 1, 3, 2}, 2.5, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, ok := calculateMedian(test.weights); got != test.want || ok != test.wantOK {
+				t.Errorf("got %v, %t, want %v, %t", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestStrategyFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategies map[string]string
+		defaults   string
+		headers    []string
+		want       SelectionStrategy
+	}{
+		{"nothing configured", nil, "", []string{"Biographies"}, weightedStrategy{}},
+		{"default", nil, "uniform", []string{"Biographies"}, uniformStrategy{}},
+		{"request type", map[string]string{"request for comment": "leastrecent"}, "uniform", []string{"Biographies"}, leastRecentStrategy{}},
+		{"header", map[string]string{"Biographies": "roundrobin", "request for comment": "leastrecent"}, "", []string{"Biographies", "History"}, roundRobinStrategy{}},
+		{"headers agree", map[string]string{"Biographies": "roundrobin", "History": "roundrobin"}, "", []string{"Biographies", "History"}, roundRobinStrategy{}},
+		{"headers disagree", map[string]string{"Biographies": "roundrobin", "History": "uniform", "request for comment": "leastrecent"}, "", []string{"Biographies", "History"}, leastRecentStrategy{}},
+		{"unknown header strategy", map[string]string{"Biographies": "nonsense", "request for comment": "leastrecent"}, "", []string{"Biographies"}, leastRecentStrategy{}},
+		{"unknown default", nil, "nonsense", []string{"Biographies"}, weightedStrategy{}},
+	}
+
+	oldConfig := yapperconfig.Config
+	defer func() { yapperconfig.Config = oldConfig }()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			yapperconfig.Config.SelectionStrategies = test.strategies
+			yapperconfig.Config.DefaultSelectionStrategy = test.defaults
+			if got := strategyFor(test.headers, "request for comment"); got != test.want {
+				t.Errorf("got %T, want %T", got, test.want)
+			}
+		})
+	}
+}
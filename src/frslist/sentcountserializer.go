@@ -19,6 +19,9 @@ package frslist
 //
 
 import (
+	"time"
+	"yapperbot-frs/src/clock"
+
 	"github.com/antonholmquist/jason"
 	"github.com/mashedkeyboard/ybtools/v2"
 )
@@ -27,12 +30,38 @@ import (
 // information, and adds the sent counts into a map, mapping headers to usernames
 // and usernames to numbers of messages sent. It returns this map as a
 // map[string]map[string]uint16.
-func deserializeSentCount(json *jason.Object) (sc map[string]map[string]uint16) {
-	sc = map[string]map[string]uint16{} // initialise the map
+func deserializeSentCount(json *jason.Object) map[string]map[string]uint16 {
 	headers, err := json.GetObject("headers")
 	if err != nil {
 		ybtools.PanicErr("Failed to deserialize sent count headers, is the JSON invalid?")
 	}
+	return deserializeHeaderCounts(headers)
+}
+
+// deserializeHistory takes a jason JSON object containing the SentCount.json information,
+// and returns the archived sent counts for previous months, mapping months to headers,
+// headers to usernames, and usernames to numbers of messages sent. Older SentCount.json
+// pages don't have this, so it's fine for it to be missing.
+func deserializeHistory(json *jason.Object) (h map[string]map[string]map[string]uint16) {
+	h = map[string]map[string]map[string]uint16{}
+	months, err := json.GetObject("history")
+	if err != nil {
+		return
+	}
+	for month, headers := range months.Map() {
+		headers, err := headers.Object()
+		if err != nil {
+			ybtools.PanicErr("history month wasn't an object, I can't handle this! the JSON seems invalid.")
+		}
+		h[month] = deserializeHeaderCounts(headers)
+	}
+	return
+}
+
+// deserializeHeaderCounts takes a jason JSON object mapping headers to usernames, and
+// usernames to numbers of messages sent, and returns it as a map[string]map[string]uint16.
+func deserializeHeaderCounts(headers *jason.Object) (sc map[string]map[string]uint16) {
+	sc = map[string]map[string]uint16{} // initialise the map
 	for header, users := range headers.Map() {
 		sc[header] = map[string]uint16{} // initialise the submap
 
@@ -52,3 +81,102 @@ func deserializeSentCount(json *jason.Object) (sc map[string]map[string]uint16)
 	}
 	return
 }
+
+// deserializeSentTimes takes a jason JSON object containing the SentCount.json
+// information, and returns a map of headers to usernames, and usernames to the times
+// they were sent each message recently. Older SentCount.json pages don't have this,
+// so it's fine for it to be missing.
+func deserializeSentTimes(json *jason.Object) (st map[string]map[string][]time.Time) {
+	st = map[string]map[string][]time.Time{}
+	headers, err := json.GetObject("sent")
+	if err != nil {
+		return
+	}
+	for header, users := range headers.Map() {
+		st[header] = map[string][]time.Time{}
+
+		users, err := users.Object()
+		if err != nil {
+			ybtools.PanicErr("sent users wasn't an object, I can't handle this! the JSON seems invalid.")
+		}
+		for user, timestamps := range users.Map() {
+			timestamps, err := timestamps.Array()
+			if err != nil {
+				ybtools.PanicErr("sent timestamps wasn't an array, I can't handle this! the JSON seems invalid.")
+			}
+			for _, timestamp := range timestamps {
+				timestampString, err := timestamp.String()
+				if err != nil {
+					ybtools.PanicErr("sent timestamp wasn't a string, I can't handle this! the JSON seems invalid.")
+				}
+				sent, err := time.Parse(time.RFC3339, timestampString)
+				if err != nil {
+					ybtools.PanicErr("sent timestamp wasn't a valid timestamp, I can't handle this! the JSON seems invalid.")
+				}
+				st[header][user] = append(st[header][user], sent)
+			}
+		}
+	}
+	return
+}
+
+// serializeSentTimes turns the sentTimes map into a map of headers to usernames, and usernames
+// to RFC3339 timestamps, ready to be serialized into JSON. Only the timestamps within sentTimesRetention
+// are kept, as those are all the caps and rolling limits need.
+func serializeSentTimes() map[string]map[string][]string {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+
+	cutoff := clock.Now().Add(-sentTimesRetention())
+	serialized := map[string]map[string][]string{}
+	for header, users := range sentTimes {
+		for user, times := range users {
+			for _, sent := range times {
+				if !sent.After(cutoff) {
+					continue
+				}
+				if serialized[header] == nil {
+					serialized[header] = map[string][]string{}
+				}
+				serialized[header][user] = append(serialized[header][user], sent.UTC().Format(time.RFC3339))
+			}
+		}
+	}
+	return serialized
+}
+
+// deserializeLastSent takes a jason JSON object containing the SentCount.json
+// information, and returns a map of usernames to the last time they were sent a message.
+// Older SentCount.json pages don't have this, so it's fine for it to be missing.
+func deserializeLastSent(json *jason.Object) (ls map[string]time.Time) {
+	ls = map[string]time.Time{}
+	users, err := json.GetObject("lastsent")
+	if err != nil {
+		return
+	}
+	for user, timestamp := range users.Map() {
+		timestampString, err := timestamp.String()
+		if err != nil {
+			ybtools.PanicErr("lastsent timestamp wasn't a string, I can't handle this! the JSON seems invalid.")
+		}
+		sent, err := time.Parse(time.RFC3339, timestampString)
+		if err != nil {
+			ybtools.PanicErr("lastsent timestamp wasn't a valid timestamp, I can't handle this! the JSON seems invalid.")
+		}
+		ls[user] = sent
+	}
+	return
+}
+
+// serializeLastSent turns the lastSent map into a map of usernames to RFC3339 timestamps,
+// ready to be serialized into JSON.
+func serializeLastSent() map[string]string {
+	sentCountMux.Lock()
+	defer sentCountMux.Unlock()
+
+	serialized := map[string]string{}
+	for user, sent := range lastSent {
+		serialized[user] = sent.UTC().Format(time.RFC3339)
+	}
+	return serialized
+}
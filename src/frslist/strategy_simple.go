@@ -0,0 +1,121 @@
+package frslist
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"math"
+	"sort"
+)
+
+// uniformStrategy is a SelectionStrategy that picks users uniformly at random,
+// ignoring how many messages they've had and which header they're under.
+type uniformStrategy struct{}
+
+// Select picks up to n of the candidates uniformly at random.
+func (uniformStrategy) Select(candidates []*FRSUser, allHeader string, n int) []*FRSUser {
+	return pickUnique(shuffled(candidates), n)
+}
+
+// leastRecentStrategy is a SelectionStrategy that picks the users who were messaged
+// longest ago first, with users who've never been messaged at the very front.
+// Ties are broken randomly.
+type leastRecentStrategy struct{}
+
+// Select picks the n candidates who were least recently messaged.
+func (leastRecentStrategy) Select(candidates []*FRSUser, allHeader string, n int) []*FRSUser {
+	// shuffle first, so that the stable sort breaks ties randomly
+	ordered := shuffled(candidates)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].GetLastSent().Before(ordered[j].GetLastSent())
+	})
+	return pickUnique(ordered, n)
+}
+
+// roundRobinStrategy is a SelectionStrategy doing deficit round robin across headers. Each round,
+// every header is given a credit - the all header only half a credit, so that specific headers are
+// preferred - and each whole credit a header has is spent on picking its user with the most room
+// left under their limit. Headers that run out of users drop out. This spreads each request evenly
+// across the headers it matches, however many people are subscribed to each.
+type roundRobinStrategy struct{}
+
+// Select picks up to n of the candidates, taking turns between their headers.
+func (roundRobinStrategy) Select(candidates []*FRSUser, allHeader string, n int) []*FRSUser {
+	// group the candidates by header, keeping the headers in a random order
+	// so that no header always gets to go first
+	var headers []string
+	queues := map[string][]*FRSUser{}
+	for _, user := range shuffled(candidates) {
+		if _, ok := queues[user.Header]; !ok {
+			headers = append(headers, user.Header)
+		}
+		queues[user.Header] = append(queues[user.Header], user)
+	}
+
+	// within each header, the users with the most room left under their limit go first
+	for _, queue := range queues {
+		sort.SliceStable(queue, func(i, j int) bool {
+			return remainingAllowance(queue[i]) > remainingAllowance(queue[j])
+		})
+	}
+
+	returnedUsers := make([]*FRSUser, 0, n)
+	usersSelected := map[string]bool{}
+	credits := map[string]float64{}
+	for len(returnedUsers) < n && len(queues) > 0 {
+		for _, header := range headers {
+			if _, ok := queues[header]; !ok {
+				continue
+			}
+
+			if header == allHeader {
+				credits[header] += 0.5
+			} else {
+				credits[header]++
+			}
+
+			for credits[header] >= 1 && len(returnedUsers) < n {
+				// drop anyone already picked from another header
+				for len(queues[header]) > 0 && usersSelected[queues[header][0].Username] {
+					queues[header] = queues[header][1:]
+				}
+				if len(queues[header]) == 0 {
+					// an empty header loses its turn and its credit, as in any deficit round robin
+					delete(queues, header)
+					break
+				}
+
+				user := queues[header][0]
+				queues[header] = queues[header][1:]
+				returnedUsers = append(returnedUsers, user)
+				usersSelected[user.Username] = true
+				credits[header]--
+			}
+		}
+	}
+	return returnedUsers
+}
+
+// remainingAllowance returns how many more messages a subscription can be sent this month,
+// less any being held for a digest, treating unlimited subscriptions as having all the room in the world.
+func remainingAllowance(user *FRSUser) int {
+	if !user.Limited {
+		return math.MaxInt32
+	}
+	return int(user.Limit) - int(user.GetCount()) - int(user.GetHeldCount())
+}
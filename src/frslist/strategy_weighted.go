@@ -0,0 +1,220 @@
+package frslist
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"log"
+	"sort"
+	"yapperbot-frs/src/random"
+)
+
+// weightedStrategy is the default SelectionStrategy. It randomly selects users, weighting them
+// by how far through their limit they are, so that people who've had fewer messages are more likely
+// to get the next one, and giving users under the all header half the chance of everyone else.
+type weightedStrategy struct{}
+
+// frsWeightedUser extends FRSUser to add a weighting component. It's only used within frslist.
+type frsWeightedUser struct {
+	*FRSUser
+	// weight will represent our probability for this user to be selected
+	weight float64
+	// _hasAllHeaderChecked is a simple boolean check to make sure we don't halve the probability twice
+	_hasAllHeaderChecked bool
+}
+
+// checkWeightForAllHeader takes a string representation of the "All [type]s" header, and checks whether
+// the frsWeightedUser is contained within that header. If it is, it will halve the user's weighting, to
+// encourage users subscribed to specific categories to be selected.
+func (u *frsWeightedUser) checkWeightForAllHeader(allHeader string) {
+	if !u._hasAllHeaderChecked {
+		// if the all header is set, give those users half the probability of receiving the message.
+		// we should try and make sure our messages are being sent to specific categories more of the time,
+		// but we should still make sure users under the all headers receive messages.
+		// this needs to be done here so that they are ordered correctly; as we're later inverting the probabilities,
+		// the weight also has to be doubled, not halved, counterintuitively
+		if allHeader != "" && u.Header == allHeader {
+			u.weight = u.weight * 2
+		}
+		u._hasAllHeaderChecked = true
+	}
+}
+
+// Select randomly selects up to n of the candidates. It weights the users based on how far through their limit
+// they are, in an attempt to spread things out a bit.
+func (weightedStrategy) Select(candidates []*FRSUser, allHeader string, n int) (returnedUsers []*FRSUser) {
+	var weightedUsers []*frsWeightedUser
+	// used to check in o(1) time whether we've already
+	// selected this user, just on another header
+	var usersSelected = map[string]bool{}
+
+	// start our returnedUsers off with a zero-length slice of cap n
+	returnedUsers = make([]*FRSUser, 0, n)
+
+	// unlimitedUsers stores all of our users who have no limit set.
+	// calculatedWeights stores all of the weights that we have otherwise
+	// calculated.
+	// these two are used to later calculate the median of the calculated weights,
+	// and set each of the unlimited users to be weighted the same as the median.
+	var unlimitedUsers []*frsWeightedUser
+	var calculatedWeights []float64
+
+	// Weight each of the candidates
+	for _, user := range candidates {
+		var weight float64
+		if user.Limited {
+			if user.GetCount() == 0 {
+				// if the user has not been sent anything, prioritise them for
+				// sending; this seems a reasonable way of weighting
+				weight = 0
+			} else {
+				// the user has been sent something, and has a limit set.
+				// weight them on the basis of their relative position
+				// within their limit. this means users with a higher limit
+				// will be more likely to receive more messages than users
+				// with a lower limit, and vice versa;
+				// however, it also keeps users with high limits from receiving
+				// all the messages, when other users are lacking anything sent.
+				weight = float64(user.GetCount()) / float64(user.Limit)
+			}
+
+			// shift each weight forward by 1 to avoid issues with dividing by zero, and to avoid
+			// zero probabilities; we want users with no current sent messages to be top-priority,
+			// definitely not zero priority
+			weight = weight + 1
+			calculatedWeights = append(calculatedWeights, weight)
+
+			// check the user for inclusion in the allHeader, halve their probability if they are,
+			// and then append them to the list of users
+			wUser := &frsWeightedUser{FRSUser: user, weight: weight}
+			wUser.checkWeightForAllHeader(allHeader)
+			weightedUsers = append(weightedUsers, wUser)
+		} else {
+			// if the user has no limit set, add them to unlimitedUsers as well as weightedUsers;
+			// we'll set their weight to the median weight later
+			wUser := &frsWeightedUser{FRSUser: user}
+			unlimitedUsers = append(unlimitedUsers, wUser)
+			weightedUsers = append(weightedUsers, wUser)
+		}
+	}
+
+	// If there are any users in the header who have no limits set, set their weighting to the median of the limited users
+	if len(unlimitedUsers) > 0 {
+		median, hasMedian := calculateMedian(calculatedWeights)
+		if !hasMedian {
+			// if all users are unlimited, then just set their weights to 1 -
+			// they're all the same anyway then, so it doesn't make a difference
+			median = 1
+		}
+		for _, user := range unlimitedUsers {
+			user.weight = median
+			// even for unlimited users, we want to give people in specific category headers more of a chance,
+			// so we should still run the AllHeader weight check here
+			user.checkWeightForAllHeader(allHeader)
+		}
+	}
+
+	// Check if we actually have an opportunity to randomly select at all here
+	if len(weightedUsers) <= n {
+		// very small list, or very large n
+		// just give the entire list, making sure we don't pick anyone twice
+		for _, wuser := range weightedUsers {
+			if !usersSelected[wuser.Username] {
+				returnedUsers = append(returnedUsers, wuser.FRSUser)
+				usersSelected[wuser.Username] = true
+			}
+		}
+		return
+	}
+
+	// Sort the list into increasing order of sent count this month
+	sort.Slice(weightedUsers, func(i, j int) bool {
+		return weightedUsers[i].weight < weightedUsers[j].weight
+	})
+
+	// Calculate cumulative sent counts for the users
+	var cumulativeSentCount float64
+
+	for _, user := range weightedUsers {
+		// take the reciprocal of the weight, and use it cumulatively.
+		// we do this here to ensure that our sent counts are used
+		// as a decentive for sending new messages.
+		cumulativeSentCount += 1 / user.weight
+		weight := float64(cumulativeSentCount)
+
+		user.weight = weight
+	}
+
+	// Select a random user each time based on our weights
+	var i = 0
+	// if users are subscribed under more than one of the headers, we can run out of users
+	// before we've picked n of them, so stop if there's nobody left
+	for i < n && len(weightedUsers) > 0 {
+		// adjust our random value to be within our bounds - going up to the
+		// final weight as a maximum value possible
+		randomValue := random.Float64() * weightedUsers[len(weightedUsers)-1].weight
+
+		selectedUserIndex := sort.Search(len(weightedUsers), func(i int) bool {
+			// find the smallest weight user whose weight is greater than our random selection
+			return weightedUsers[i].weight > randomValue
+		})
+
+		if selectedUserIndex > len(weightedUsers)-1 {
+			// the selection index hasn't been found; this is probably because we've exhausted our search somehow
+			// just return what we've got, and mark in the log that this happened - it shouldn't ever happen
+			log.Println("WARNING: Exhausted search space for selectedUserIndex, returning what we can get: asked for", n, "and got", len(returnedUsers))
+			return
+		}
+
+		// make sure we haven't already picked this user
+		if !usersSelected[weightedUsers[selectedUserIndex].Username] {
+			// assuming we haven't, add them to our list...
+			returnedUsers = append(returnedUsers, weightedUsers[selectedUserIndex].FRSUser)
+			// mark them as picked...
+			usersSelected[weightedUsers[selectedUserIndex].Username] = true
+			// and increase the number of users we've picked up to n
+			i++
+		}
+
+		// make sure we're not keeping the same users around, potentially selecting them
+		// multiple times
+		if selectedUserIndex+1 == len(weightedUsers) {
+			// this is the end of the slice; just chop the end off
+			weightedUsers = weightedUsers[:selectedUserIndex]
+		} else {
+			// we need to chop this element, and only this element, out
+			weightedUsers = append(weightedUsers[:selectedUserIndex], weightedUsers[selectedUserIndex+1:]...)
+		}
+	}
+
+	return
+}
+
+// calculateMedian takes a slice of float64s and returns the median if there is one, and a bool indicating if a median
+// could be calculated (i.e. if the given slice has a length greater than zero).
+func calculateMedian(calculatedWeights []float64) (float64, bool) {
+	if len(calculatedWeights) > 0 {
+		sort.Float64s(calculatedWeights)
+		middleIndex := len(calculatedWeights) / 2
+		if len(calculatedWeights)%2 == 0 {
+			return (calculatedWeights[middleIndex-1] + calculatedWeights[middleIndex]) / 2, true
+		}
+		return calculatedWeights[middleIndex], true
+	}
+	return 0, false
+}
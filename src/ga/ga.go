@@ -52,6 +52,12 @@ func (n Nom) IncludeHeader(header string) (bool, bool) {
 	return false, false
 }
 
+// KnownHeader returns whether a FRS header is for GA nominations in a known topic or subtopic.
+// The GA topics must have been fetched first.
+func KnownHeader(header string) bool {
+	return strings.HasPrefix(header, gaPrefix) && IsTopic(strings.TrimPrefix(header, gaPrefix))
+}
+
 // PageTitle is a simple getter for the GA nominee article in order to make the interface work
 func (n Nom) PageTitle() string {
 	return n.Article
@@ -20,6 +20,7 @@ package ga
 
 import (
 	"regexp"
+	"yapperbot-frs/src/wiki"
 	"yapperbot-frs/src/yapperconfig"
 
 	"github.com/mashedkeyboard/ybtools/v2"
@@ -28,6 +29,10 @@ import (
 // gaTopics is a map storing the Good Article topics in the form {"subtopic": "topic"}
 var gaTopics map[string]string
 
+// gaTopicsFetched records whether we've already fetched the topics this run,
+// as more than one requester type uses them.
+var gaTopicsFetched bool
+
 // gaTopicsRegex matches each GA topic from the on-wiki list of GA topics.
 var gaTopicsRegex *regexp.Regexp
 
@@ -47,8 +52,14 @@ func init() {
 }
 
 // FetchGATopics fetches the latest GA topics from the Good Article noms page.
-func FetchGATopics() {
-	text, err := ybtools.FetchWikitext(yapperconfig.Config.GAGuidelinesHeaderPageID)
+// It only fetches them once per run, however many times it's called.
+func FetchGATopics(w wiki.Wiki) {
+	if gaTopicsFetched {
+		return
+	}
+	gaTopicsFetched = true
+
+	text, err := w.FetchWikitext(yapperconfig.Config.GAGuidelinesHeaderPageID)
 	if err != nil {
 		ybtools.PanicErr("Failed to fetch Good Articles topics with error ", err)
 	}
@@ -62,3 +73,22 @@ func FetchGATopics() {
 		}
 	}
 }
+
+// IsTopic returns whether name is a known GA topic or subtopic.
+func IsTopic(name string) bool {
+	if _, isSubtopic := gaTopics[name]; isSubtopic {
+		return true
+	}
+	for _, topic := range gaTopics {
+		if topic == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TopicForSubtopic takes a GA subtopic, and returns the topic it belongs to,
+// or empty string if it's not a known subtopic.
+func TopicForSubtopic(subtopic string) string {
+	return gaTopics[subtopic]
+}
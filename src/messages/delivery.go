@@ -0,0 +1,62 @@
+package messages
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"yapperbot-frs/src/frslist"
+	"yapperbot-frs/src/wiki"
+
+	"cgt.name/pkg/go-mwclient"
+)
+
+// A deliveryChannel is a way of getting a user's queued messages to them.
+type deliveryChannel interface {
+	// name returns the name of the channel, for logs and the run report.
+	name() string
+
+	// deliver sends all of a user's queued messages to them at once.
+	deliver(w wiki.Wiki, user string, messages []*Message) error
+
+	// fallback returns the channel to try instead if deliver fails with an error
+	// that shouldFallBack accepts, or nil if there isn't one.
+	fallback() deliveryChannel
+}
+
+// channels maps each delivery preference from the FRS list to its deliveryChannel.
+var channels = map[string]deliveryChannel{
+	frslist.DeliveryTalkPage: talkPageDelivery{},
+	frslist.DeliveryEmail:    emailDelivery{},
+}
+
+// channelFor returns the deliveryChannel a user would like their messages sent through.
+func channelFor(user string) deliveryChannel {
+	return channels[frslist.GetDeliveryPreference(user)]
+}
+
+// shouldFallBack returns whether an error from a deliveryChannel means the user just
+// can't be reached that way, so their messages should go through the channel's fallback.
+func shouldFallBack(err error) bool {
+	if apiErr, ok := err.(mwclient.APIError); ok {
+		switch apiErr.Code {
+		case "noemail", "nowikiemail", "usermaildisabled", "blockedfrommail", "mailerror":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package messages
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"log"
+	"time"
+	"yapperbot-frs/src/clock"
+	"yapperbot-frs/src/eligibility"
+	"yapperbot-frs/src/frslist"
+	"yapperbot-frs/src/report"
+	"yapperbot-frs/src/state"
+)
+
+// digestsKey is the key in the state store that the messages held for users' digests are kept under.
+const digestsKey string = "digests"
+
+// A digestEntry is a message held for a user's digest, as it's kept in the state store.
+type digestEntry struct {
+	Username string `json:"username"`
+	Header   string `json:"header"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	RFCID    string `json:"rfcid,omitempty"`
+	Question string `json:"question,omitempty"`
+	// Digest is frslist.DigestDaily or frslist.DigestWeekly, and Held is when the message was held.
+	Digest string    `json:"digest"`
+	Held   time.Time `json:"held"`
+}
+
+// heldMessages are the messages being held for users' digests, in the order they were held.
+var heldMessages []heldMessage
+
+// A heldMessage is a message being held for a user's digest, along with which digest and when it was held.
+type heldMessage struct {
+	message *Message
+	digest  string
+	held    time.Time
+}
+
+// digestKey identifies one of a user's digests; a user has separate daily and weekly digests
+// if they've asked for both on different subscriptions.
+type digestKey struct {
+	username, digest string
+}
+
+// QueueDigests takes a function returning whether a request is still open, given its type,
+// the title of the page it's on and its RfC ID (if it has one), and loads the messages held
+// for users' digests. A digest is due once the oldest message in it has been held for the
+// digest's period; every message in a due digest is queued, so that they all go out together,
+// except for those about requests that have since closed, which are dropped. Digests for paused
+// subscriptions are held until the pause is over. Messages in a due digest are checked against the
+// limits on what's actually been sent, so any that would take the user over them are held for the next
+// run, when the digest will still be due, as are any for users eligibility says can't be messaged.
+// Every message that stays held is counted when picking users, so no-one is picked for more than
+// their limits allow. It should be run before any requests are processed, so that due digests get
+// the first call on users' limits, and the held messages are counted.
+func QueueDigests(stillOpen func(requestType, title, rfcID string) bool) {
+	var stored []digestEntry
+	state.Get(digestsKey, &stored)
+
+	oldest := map[digestKey]time.Time{}
+	for _, entry := range stored {
+		key := digestKey{entry.Username, entry.Digest}
+		if held, ok := oldest[key]; !ok || entry.Held.Before(held) {
+			oldest[key] = entry.Held
+		}
+	}
+
+	for _, entry := range stored {
+		user := frslist.GetSubscription(entry.Username, entry.Header)
+		if user == nil {
+			log.Println("Dropped the message held for", entry.Username, "about", entry.Title+", as they're no longer subscribed to the header")
+			report.MessageDropped(entry.Username, entry.Title, entry.Type, 0, "the user is no longer subscribed to the header")
+			continue
+		}
+
+		message := &Message{
+			User:     user,
+			Type:     entry.Type,
+			Title:    entry.Title,
+			RFCID:    entry.RFCID,
+			Question: entry.Question,
+		}
+
+		due := !clock.Now().Before(oldest[digestKey{entry.Username, entry.Digest}].Add(frslist.DigestPeriod(entry.Digest)))
+		if !due || user.IsPaused() {
+			keepHeld(message, entry.Digest, entry.Held)
+			continue
+		}
+
+		if !stillOpen(entry.Type, entry.Title, entry.RFCID) {
+			log.Println("Dropped the message held for", entry.Username, "about", entry.Title+", as the request closed before their digest was due")
+			report.MessageDropped(entry.Username, entry.Title, entry.Type, 0, "the request closed before the digest was due")
+			continue
+		}
+
+		if user.ExceedsLimit() || !eligibility.Eligible(user.Username) {
+			log.Println("Still holding the message for", entry.Username, "about", entry.Title+", as they can't be sent messages at the moment")
+			keepHeld(message, entry.Digest, entry.Held)
+			continue
+		}
+
+		log.Println("Queued the message held for", entry.Username, "about", entry.Title, "as their", entry.Digest, "digest is due")
+		message.fromDigest = true
+		QueueMessage(message)
+	}
+	saveDigests()
+}
+
+// HeldMessages returns every message being held for users' digests, including those held this run.
+// It's used by the simulator, where held messages count as selections just like queued ones.
+func HeldMessages() []*Message {
+	messages := make([]*Message, 0, len(heldMessages))
+	for _, held := range heldMessages {
+		messages = append(messages, held.message)
+	}
+	return messages
+}
+
+// holdForDigest takes a message for a user who gets their messages for its header in a digest,
+// and holds it for their next digest, rather than queueing it to be sent this run.
+func holdForDigest(m *Message) {
+	keepHeld(m, m.User.Digest, clock.Now())
+	saveDigests()
+
+	log.Println("Held the message for", m.User.Username, "about", m.Title, "for their", m.User.Digest, "digest")
+	report.MessageHeld(m.User.Username, m.Title, m.Type, m.User.Digest)
+}
+
+// keepHeld takes a message, the digest it's held for and the time it was first held,
+// and keeps it held, counting it against the user's limits when they're picked.
+func keepHeld(m *Message, digest string, heldAt time.Time) {
+	heldMessages = append(heldMessages, heldMessage{message: m, digest: digest, held: heldAt})
+	m.User.MarkMessageHeld()
+}
+
+// saveDigests stores the messages being held for users' digests in the state store.
+func saveDigests() {
+	stored := make([]digestEntry, 0, len(heldMessages))
+	for _, held := range heldMessages {
+		stored = append(stored, digestEntry{
+			Username: held.message.User.Username,
+			Header:   held.message.User.Header,
+			Type:     held.message.Type,
+			Title:    held.message.Title,
+			RFCID:    held.message.RFCID,
+			Question: held.message.Question,
+			Digest:   held.digest,
+			Held:     held.held,
+		})
+	}
+	state.Set(digestsKey, stored)
+}
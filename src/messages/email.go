@@ -0,0 +1,91 @@
+package messages
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"net/url"
+	"strings"
+	"yapperbot-frs/src/frslist"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// frsPageTitle is the title of the FRS list, linked to from emails so people can manage their subscriptions.
+const frsPageTitle string = "Wikipedia:Feedback request service"
+
+// emailDelivery is the deliveryChannel that sends messages in a single plain text email,
+// through Special:EmailUser. Users who can't be emailed get their messages on their talk page.
+type emailDelivery struct{}
+
+// name returns the name of the channel.
+func (emailDelivery) name() string {
+	return frslist.DeliveryEmail
+}
+
+// fallback returns the talk page, for users who've asked for email but don't have it enabled.
+func (emailDelivery) fallback() deliveryChannel {
+	return talkPageDelivery{}
+}
+
+// deliver sends the user one email listing all of their messages.
+func (emailDelivery) deliver(w wiki.Wiki, user string, messages []*Message) error {
+	return w.EmailUser(user, messageTitle(messages), emailText(user, messages))
+}
+
+// emailText builds the plain text of an email to a user listing all of their messages,
+// from the emailintro, emailitem and emailfooter templates.
+func emailText(user string, messages []*Message) string {
+	var textBuilder strings.Builder
+	textBuilder.WriteString(renderTemplate(emailIntroTemplate, emailIntroData{User: user}))
+
+	for _, message := range messages {
+		var anchor string
+		if message.RFCID != "" {
+			// Legobot gives each RfC an anchor named after its ID
+			anchor = "rfc_" + message.RFCID
+		}
+		textBuilder.WriteString(renderTemplate(emailItemTemplate, emailItemData{
+			Header:   cleanedHeaders[message.User.Header],
+			Type:     message.Type,
+			Title:    message.Title,
+			Question: message.Question,
+			Link:     pageURL(message.Title, anchor),
+		}))
+	}
+
+	frsLink := pageURL(frsPageTitle, "")
+	if frsLink == "" {
+		frsLink = frsPageTitle
+	}
+	textBuilder.WriteString(renderTemplate(emailFooterTemplate, emailFooterData{FRSLink: frsLink}))
+	return textBuilder.String()
+}
+
+// pageURL takes a page title and an optional anchor, and returns the URL for the page,
+// or empty string if there's no article path configured to build it with.
+func pageURL(title, anchor string) string {
+	if yapperconfig.Config.ArticlePath == "" {
+		return ""
+	}
+	link := yapperconfig.Config.ArticlePath + (&url.URL{Path: strings.ReplaceAll(title, " ", "_")}).EscapedPath()
+	if anchor != "" {
+		link += "#" + url.PathEscape(anchor)
+	}
+	return link
+}
@@ -20,16 +20,16 @@ package messages
 //
 
 import (
-	"fmt"
 	"log"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
-	"time"
 	"yapperbot-frs/src/frslist"
+	"yapperbot-frs/src/ratelimit"
+	"yapperbot-frs/src/report"
+	"yapperbot-frs/src/wiki"
 
 	"cgt.name/pkg/go-mwclient"
-	"cgt.name/pkg/go-mwclient/params"
 	"github.com/gertd/go-pluralize"
 	"github.com/mashedkeyboard/ybtools/v2"
 )
@@ -44,6 +44,12 @@ type Message struct {
 	// Title refers to the title of the page the message is about, not the message title.
 	Title string
 	RFCID string
+	// Question is the plain text RfC question, where there is one.
+	Question string
+	// attempts is the number of times we've already tried and failed to send the message, on previous runs.
+	attempts int
+	// fromDigest is true if the message was held for the user's digest, which is now being sent.
+	fromDigest bool
 }
 
 // headerForMessageSending is a struct used to deduplicate the headers we put in our
@@ -53,24 +59,10 @@ type headerForMessageSending struct {
 	countThisRun uint16
 	user         *frslist.FRSUser
 	headerType   string
+	// title is the title of the page the first message for the header is about.
+	title string
 }
 
-// editSummaryForFeedbackMsgs is used to generate our edit summary. We run Sprintf over it
-// with the appropriately-formatted values we get back from editSummaryMessagesComponent, joined together with
-// a limitInEditSummary formatted as necessary if the user has a limit set for the category.
-const editSummaryForFeedbackMsgs string = `[[WP:FRS|Feedback Request Service]] notification on %s. You can unsubscribe at [[WP:FRS]].`
-
-// editSummaryMessagesComponent contains the core part of our edit summary. We run Sprintf over it with:
-// %s 1: determiner "a" or "some" depending on if we have plural
-// %s 2: header the user was subscribed to
-// %s 3: the type of request (GA nom, RfC, etc), pluralised if necessary
-// %s 4: limitInEditSummary, or empty string for no limit
-const editSummaryMessagesComponent string = `%s "%s" %s%s`
-
-// limitInEditSummary is used where users have a limit set.
-// Sprintf is run over it with the first param as the used amount, and the second as the limit.
-const limitInEditSummary string = ` (%d/%d this month)`
-
 // messagesToSend is our username-indexed list of messages that we have queued.
 // Each username key maps to a list of messages we have stored up to send them this run.
 var messagesToSend = map[string][]*Message{}
@@ -84,6 +76,10 @@ var commentRegex *regexp.Regexp
 // using the commentRegex.
 var cleanedHeaders = map[string]string{}
 
+// paramEscaper replaces the characters that have special meaning inside a template
+// parameter with their HTML entities, so plain text can be safely passed to a template.
+var paramEscaper = strings.NewReplacer("|", "&#124;", "{", "&#123;", "}", "&#125;", "[", "&#91;", "]", "&#93;", "~", "&#126;")
+
 // pluralizer is used to turn singular words into plurals; specifically,
 // we use it here to pluralise the GA/RfC/whatever requester headers
 // in the edit summary we leave.
@@ -96,136 +92,114 @@ func init() {
 
 // QueueMessage takes a pointer to a Message, and adds it into our queue
 // of messages to send to this user once we've finished our run and we're actually
-// sending the messages that we've processed.
+// sending the messages that we've processed. If the user gets their messages for the
+// header in a digest, the message is held for that instead; messages being retried or
+// sent in a digest have already been held, so they're always queued.
 func QueueMessage(m *Message) {
+	if m.User.Digest != "" && m.attempts == 0 && !m.fromDigest {
+		holdForDigest(m)
+		return
+	}
 	messagesToSend[m.User.Username] = append(messagesToSend[m.User.Username], m)
 	m.User.MarkMessageSent()
 }
 
-// SendMessageQueue takes a pointer to an mwclient instance, and sends all the queued
-// messages from the FRS run.
-func SendMessageQueue(w *mwclient.Client) {
-	for user, messages := range messagesToSend {
-		var textBuilder strings.Builder
-
-		// headersInSummary is just used to make sure our edit summary only has each header once.
-		// it maps each header for the summary to a number of times the header has been used.
-		// each header should be stored against its ''cleaned'' key, not its internal name.
-		var headersInSummary = map[string]*headerForMessageSending{}
-
-		textBuilder.WriteString("{{subst:FRS notification")
-
-		for index, message := range messages {
-			strindex := strconv.Itoa(index)
-			cleanedHeader := cleanedHeaders[message.User.Header]
-			numberedParamToBuilder(&textBuilder, strindex, "title")
-			textBuilder.WriteString(message.Title)
-			numberedParamToBuilder(&textBuilder, strindex, "header")
-			textBuilder.WriteString(cleanedHeader)
-			numberedParamToBuilder(&textBuilder, strindex, "type")
-			textBuilder.WriteString(message.Type)
-			if message.RFCID != "" {
-				numberedParamToBuilder(&textBuilder, strindex, "rfcid")
-				textBuilder.WriteString(message.RFCID)
-			}
-
-			if header, ok := headersInSummary[cleanedHeader]; ok {
-				// we already have the header in the list. use it.
-				header.countThisRun++
-			} else {
-				// the header hasn't yet been used, create it
-				headersInSummary[cleanedHeader] = &headerForMessageSending{
-					countThisRun: 1,
-					user:         message.User,
-					headerType:   message.Type,
-				}
-			}
-		}
+// QueuedMessages returns every message queued so far, mapped from the username they're queued for.
+// It's used by the simulator, which never sends anything.
+func QueuedMessages() map[string][]*Message {
+	return messagesToSend
+}
+
+// DiscardMessages throws away every message queued or held for a digest so far, without sending
+// any of them. It's used by the simulator between its runs.
+func DiscardMessages() {
+	messagesToSend = map[string][]*Message{}
+	heldMessages = nil
+}
 
-		textBuilder.WriteString("}} ~~~~")
-		var notificationText string = textBuilder.String()
+// SendMessageQueue takes a Wiki, and sends all the queued messages from the FRS run,
+// each user's messages going out together through the delivery channel they prefer.
+// Users are sent their messages concurrently by a small pool of workers, paced by a
+// token bucket; see sender.go. If sending dies for any user, no more users are started,
+// and the panic is passed on once the users already being sent to are finished with.
+func SendMessageQueue(w wiki.Wiki) {
+	usernames := make([]string, 0, len(messagesToSend))
+	for user := range messagesToSend {
+		usernames = append(usernames, user)
+	}
+	sort.Strings(usernames)
 
-		var sectiontitle string
-		if len(messages) == 1 {
-			cleanedHeader := cleanedHeaders[messages[0].User.Header]
-			sectiontitle = fmt.Sprintf("Feedback request: %s %s", cleanedHeader, messages[0].Type)
-		} else {
-			sectiontitle = "Feedback requests from the Feedback Request Service"
+	s := newSender()
+	for _, user := range usernames {
+		if s.failed() {
+			break
+		}
+		if !w.CanEdit() {
+			continue
 		}
+		user := user
+		s.send(func() {
+			sendTo(w, s.bucket, user, messagesToSend[user])
+		})
+	}
+	s.wait()
+}
+
+// sendTo takes a Wiki, the token bucket pacing our sending, a username and their messages,
+// and sends the user their messages, dealing with anything that goes wrong.
+func sendTo(w wiki.Wiki, bucket *ratelimit.Bucket, user string, messages []*Message) {
+	channel := channelFor(user)
+	err := deliverPaced(w, bucket, channel, user, messages)
+	if err != nil && channel.fallback() != nil && shouldFallBack(err) {
+		log.Println("Couldn't send", user, "their messages via", channel.name(), "so falling back to", channel.fallback().name(), "- the error was", err)
+		channel = channel.fallback()
+		err = deliverPaced(w, bucket, channel, user, messages)
+	}
 
-		// Drop a note on each user's talk page inviting them to participate
-		if ybtools.CanEdit() {
-			var summarySentListBuilder strings.Builder
-			var index int
-			for headerName, header := range headersInSummary {
-				var limitsummary string
-				if header.user.Limited {
-					limitsummary = fmt.Sprintf(limitInEditSummary, header.user.GetCount(), header.user.Limit)
-				}
-
-				determiner := "a"
-				if header.countThisRun > 1 {
-					determiner = "some"
-					header.headerType = pluralizer.Plural(header.headerType)
-				}
-
-				summarySentListBuilder.WriteString(fmt.Sprintf(
-					editSummaryMessagesComponent,
-					determiner,
-					headerName,
-					header.headerType,
-					limitsummary,
-				))
-
-				if len(messages) > 1 && index != len(messages)-1 {
-					summarySentListBuilder.WriteString(", ")
-					if index == len(messages)-2 {
-						// penultimate
-						summarySentListBuilder.WriteString("and ")
-					}
-				}
-				index++
-			}
-
-			// Generate the edit summary, with their limit
-			editsummary := fmt.Sprintf(editSummaryForFeedbackMsgs, summarySentListBuilder.String())
-
-			// the redirect param here automatically resolves redirects,
-			// for instance if a user changes their username but forgets
-			// to update the FRS user tag
-			err := w.Edit(params.Values{
-				"title":        "User talk:" + user,
-				"section":      "new",
-				"sectiontitle": sectiontitle,
-				"summary":      editsummary,
-				"notminor":     "true",
-				"bot":          "true",
-				"text":         notificationText,
-				"redirect":     "true",
-			})
-			if err == nil {
-				log.Println("Successfully invited", user, "to give feedback on", len(messages), "requesting items")
-				time.Sleep(5 * time.Second)
-			} else {
-				switch err.(type) {
-				case mwclient.APIError:
-					switch err.(mwclient.APIError).Code {
-					case "noedit", "writeapidenied", "blocked":
-						ybtools.PanicErr("noedit/writeapidenied/blocked code returned, the bot may have been blocked. Dying")
-					case "pagedeleted":
-						log.Println("Looks like the user", user, "talk page was deleted while we were updating it... huh. Going for a new one!")
-					default:
-						log.Println("Error editing user talk for", user, "meant they couldn't be notified and were ignored. The error was", err)
-					}
-				default:
-					ybtools.PanicErr("Non-API error returned when trying to notify user ", user, " so dying. Error was ", err)
-				}
-				for _, message := range messages {
-					message.User.MarkMessageUnsent()
-				}
-			}
+	if err == nil {
+		log.Println("Successfully invited", user, "to give feedback on", len(messages), "requesting items via", channel.name())
+		report.MessagesSent(user, len(messages), channel.name())
+		return
+	}
+
+	var code string
+	switch err.(type) {
+	case mwclient.APIError:
+		code = err.(mwclient.APIError).Code
+		switch code {
+		case "noedit", "writeapidenied", "blocked":
+			ybtools.PanicErr("noedit/writeapidenied/blocked code returned, the bot may have been blocked. Dying")
+		case "pagedeleted":
+			log.Println("Looks like the user", user, "talk page was deleted while we were updating it... huh. Going for a new one!")
+		default:
+			log.Println("Error sending messages to", user, "meant they couldn't be notified. The error was", err)
+		}
+	default:
+		if err != mwclient.ErrAPIBusy {
+			ybtools.PanicErr("Non-API error returned when trying to notify user ", user, " so dying. Error was ", err)
 		}
+		code = "maxlag"
+		log.Println("The servers were still lagged after backing off, so", user, "couldn't be notified")
 	}
+	report.MessagesFailed(user, len(messages), code, err)
+	for _, message := range messages {
+		message.User.MarkMessageUnsent()
+		retryLater(message, err)
+	}
+}
+
+// messageTitle returns the title for a batch of messages to a user, used as
+// the talk page section title or the email subject.
+func messageTitle(messages []*Message) string {
+	if len(messages) == 1 {
+		return renderTemplate(sectionTitleTemplate, titleData{
+			Header: cleanedHeaders[messages[0].User.Header],
+			Type:   messages[0].Type,
+			Title:  messages[0].Title,
+			Count:  1,
+		})
+	}
+	return renderTemplate(sectionTitleMultipleTemplate, titleData{Count: len(messages)})
 }
 
 // CleanHeader takes a "dirty" header (a header with HTML comments in) as a string,
@@ -240,6 +214,12 @@ func CleanHeader(header string) {
 	}
 }
 
+// escapeParamValue takes some plain text, and escapes anything in it that would
+// break out of a template parameter, or be expanded when the template is substituted.
+func escapeParamValue(value string) string {
+	return paramEscaper.Replace(value)
+}
+
 // numberedParamToBuilder takes a strings.Builder, an index as a string,
 // and a parameter name to go along with that index,
 // and adds the relevant bits for a MediaWiki parameter to the builder.
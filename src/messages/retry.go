@@ -0,0 +1,196 @@
+package messages
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"yapperbot-frs/src/clock"
+	"yapperbot-frs/src/eligibility"
+	"yapperbot-frs/src/frslist"
+	"yapperbot-frs/src/report"
+	"yapperbot-frs/src/state"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// retryQueueKey is the key in the state store that the retry queue is kept under, and
+// retryDroppedKey is the key for the permanent record of messages we've given up on.
+const retryQueueKey string = "retryqueue"
+const retryDroppedKey string = "retrydropped"
+
+// defaultRetryMaxAttempts is how many times we try to send a message before giving up on it,
+// if it's not configured.
+const defaultRetryMaxAttempts int = 5
+
+// retryBaseDelay is how long we wait before trying to send a message again after it first fails.
+// The wait doubles with every failed attempt after that, up to retryMaxDelay.
+const retryBaseDelay time.Duration = time.Hour
+const retryMaxDelay time.Duration = 7 * 24 * time.Hour
+
+// retryMux guards updates to the retry queue and the record of dropped messages in the state store,
+// as messages that fail to send may be put on the queue from several goroutines at once.
+var retryMux sync.Mutex
+
+// A retryEntry is a message on the retry queue, waiting to be sent again.
+type retryEntry struct {
+	Username    string    `json:"username"`
+	Header      string    `json:"header"`
+	Type        string    `json:"type"`
+	Title       string    `json:"title"`
+	RFCID       string    `json:"rfcid,omitempty"`
+	Question    string    `json:"question,omitempty"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextattempt"`
+	LastError   string    `json:"lasterror"`
+}
+
+// A droppedEntry is the record kept of a message we've given up on, and why.
+type droppedEntry struct {
+	Username string    `json:"username"`
+	Header   string    `json:"header"`
+	Type     string    `json:"type"`
+	Title    string    `json:"title"`
+	Attempts int       `json:"attempts"`
+	Reason   string    `json:"reason"`
+	Dropped  time.Time `json:"dropped"`
+}
+
+// QueueRetries takes a function returning whether a request is still open, given its type,
+// the title of the page it's on and its RfC ID (if it has one), and queues every message on
+// the retry queue that's due to be tried again, ahead of the messages for this run's requests.
+// Messages for requests that have closed, or for subscriptions that have been removed, are dropped;
+// messages to users who've hit their limits, paused their subscription or aren't eligible for
+// messages at the moment are left on the queue for next time, without counting it as an attempt.
+func QueueRetries(stillOpen func(requestType, title, rfcID string) bool) {
+	var queue []retryEntry
+	state.Get(retryQueueKey, &queue)
+
+	var remaining []retryEntry
+	for _, entry := range queue {
+		if clock.Now().Before(entry.NextAttempt) {
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		if !stillOpen(entry.Type, entry.Title, entry.RFCID) {
+			dropMessage(entry, "the request is no longer open")
+			continue
+		}
+
+		user := frslist.GetSubscription(entry.Username, entry.Header)
+		if user == nil {
+			dropMessage(entry, "the user is no longer subscribed to the header")
+			continue
+		}
+
+		if user.IsPaused() || user.ExceedsLimit() || !eligibility.Eligible(user.Username) {
+			log.Println("Not retrying the message to", entry.Username, "about", entry.Title, "this run, as they can't be sent messages at the moment")
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		log.Println("Retrying the message to", entry.Username, "about", entry.Title, "after", entry.Attempts, "failed attempts")
+		CleanHeader(user.Header)
+		QueueMessage(&Message{
+			User:     user,
+			Type:     entry.Type,
+			Title:    entry.Title,
+			RFCID:    entry.RFCID,
+			Question: entry.Question,
+			attempts: entry.Attempts,
+		})
+	}
+	state.Set(retryQueueKey, remaining)
+}
+
+// retryLater takes a message that failed to send and the error it failed with, and puts it on
+// the retry queue to be tried again on a later run - unless it's failed too many times already,
+// in which case it's dropped.
+func retryLater(m *Message, err error) {
+	entry := retryEntry{
+		Username:  m.User.Username,
+		Header:    m.User.Header,
+		Type:      m.Type,
+		Title:     m.Title,
+		RFCID:     m.RFCID,
+		Question:  m.Question,
+		Attempts:  m.attempts + 1,
+		LastError: err.Error(),
+	}
+
+	if entry.Attempts >= retryMaxAttempts() {
+		dropMessage(entry, fmt.Sprintf("failed to send %d times, most recently with the error: %s", entry.Attempts, err))
+		return
+	}
+
+	entry.NextAttempt = clock.Now().Add(retryDelay(entry.Attempts))
+
+	retryMux.Lock()
+	var queue []retryEntry
+	state.Get(retryQueueKey, &queue)
+	state.Set(retryQueueKey, append(queue, entry))
+	retryMux.Unlock()
+
+	log.Println("Queued the message to", entry.Username, "about", entry.Title, "to be retried after", entry.NextAttempt.Format(time.RFC3339))
+	report.RetryQueued(entry.Username, entry.Title, entry.Type, entry.Attempts, entry.NextAttempt.Format(time.RFC3339))
+}
+
+// dropMessage takes a message from the retry queue and the reason we're giving up on it,
+// and adds it to the permanent record of dropped messages.
+func dropMessage(entry retryEntry, reason string) {
+	retryMux.Lock()
+	var dropped []droppedEntry
+	state.Get(retryDroppedKey, &dropped)
+	state.Set(retryDroppedKey, append(dropped, droppedEntry{
+		Username: entry.Username,
+		Header:   entry.Header,
+		Type:     entry.Type,
+		Title:    entry.Title,
+		Attempts: entry.Attempts,
+		Reason:   reason,
+		Dropped:  clock.Now(),
+	}))
+	retryMux.Unlock()
+
+	log.Println("Dropped the message to", entry.Username, "about", entry.Title+":", reason)
+	report.MessageDropped(entry.Username, entry.Title, entry.Type, entry.Attempts, reason)
+}
+
+// retryMaxAttempts returns how many times we try to send a message before giving up on it.
+func retryMaxAttempts() int {
+	if yapperconfig.Config.RetryMaxAttempts > 0 {
+		return yapperconfig.Config.RetryMaxAttempts
+	}
+	return defaultRetryMaxAttempts
+}
+
+// retryDelay takes the number of times a message has failed to send, and returns
+// how long to wait before trying it again.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+	return delay
+}
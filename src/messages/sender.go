@@ -0,0 +1,171 @@
+package messages
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"log"
+	"sync"
+	"time"
+	"yapperbot-frs/src/dryrun"
+	"yapperbot-frs/src/ratelimit"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+
+	"cgt.name/pkg/go-mwclient"
+)
+
+// defaultSendRate, defaultSendBurst and defaultSendWorkers are used for the number of deliveries
+// we make each minute, how many we can make at once before the rate kicks in,
+// and how many we send to concurrently, if they're not configured.
+const defaultSendRate float64 = 12
+const defaultSendBurst int = 3
+const defaultSendWorkers int = 3
+
+// throttleBaseDelay is how long we stop sending for the first time the servers tell us to slow down,
+// either with a ratelimited error or by still being lagged once mwclient has given up waiting on maxlag.
+// The delay doubles each time after that, and after maxThrottleRetries we give up on the user.
+const throttleBaseDelay time.Duration = 30 * time.Second
+const maxThrottleRetries int = 4
+
+// A sender runs a pool of workers, each sending users their messages, all paced by the same token bucket.
+// A user's messages are always sent by a single worker, in order, so only the order across users changes.
+type sender struct {
+	// bucket is nil in a dry run, where nothing is actually sent, so there's nothing to pace.
+	bucket *ratelimit.Bucket
+	jobs   chan func()
+	wg     sync.WaitGroup
+
+	// panicked is the first panic from any worker, which is passed on by wait.
+	panicked interface{}
+	panicMux sync.Mutex
+}
+
+// newSender sets up a sender, and starts its workers. In a dry run, there's only one worker and
+// no pacing, so that the planned edits are quick to make and always come out in the same order.
+func newSender() *sender {
+	s := &sender{jobs: make(chan func())}
+	workers := 1
+	if !dryrun.Enabled() {
+		s.bucket = ratelimit.NewBucket(sendRate(), sendBurst())
+		workers = sendWorkers()
+	}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+	return s
+}
+
+// send hands a job to the next free worker, waiting for one if they're all busy.
+func (s *sender) send(job func()) {
+	s.jobs <- job
+}
+
+// failed returns whether any job has panicked, in which case nothing more should be sent.
+func (s *sender) failed() bool {
+	s.panicMux.Lock()
+	defer s.panicMux.Unlock()
+	return s.panicked != nil
+}
+
+// wait waits for every job handed out to finish, and then passes on the first panic from them, if there was one.
+// A panic in a goroutine can't be recovered from anywhere else, so if we didn't catch it in the worker and pass
+// it on here, the bot would die without running any of its deferred saves.
+func (s *sender) wait() {
+	close(s.jobs)
+	s.wg.Wait()
+	if s.panicked != nil {
+		panic(s.panicked)
+	}
+}
+
+// work runs jobs until there are none left.
+func (s *sender) work() {
+	defer s.wg.Done()
+	for job := range s.jobs {
+		s.run(job)
+	}
+}
+
+// run runs a single job, catching it if it panics.
+func (s *sender) run(job func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.panicMux.Lock()
+			defer s.panicMux.Unlock()
+			if s.panicked == nil {
+				s.panicked = r
+			}
+		}
+	}()
+	job()
+}
+
+// deliverPaced takes a Wiki, the token bucket pacing our sending, a delivery channel, a username and their
+// messages, and delivers the messages through the channel once the bucket allows it. If the servers tell us
+// to slow down, every worker backs off, and the delivery is tried again, up to maxThrottleRetries times.
+// If the bucket is nil, the messages are delivered straight away.
+func deliverPaced(w wiki.Wiki, bucket *ratelimit.Bucket, channel deliveryChannel, user string, messages []*Message) error {
+	for attempt := 0; ; attempt++ {
+		if bucket != nil {
+			bucket.Wait()
+		}
+		err := channel.deliver(w, user, messages)
+		if bucket == nil || !throttled(err) || attempt == maxThrottleRetries {
+			return err
+		}
+
+		delay := throttleBaseDelay << uint(attempt)
+		log.Println("The servers asked us to slow down while sending", user, "their messages, so backing off for", delay, "- the error was", err)
+		bucket.PauseFor(delay)
+	}
+}
+
+// throttled returns whether an error means the servers want us to slow down.
+func throttled(err error) bool {
+	if err == mwclient.ErrAPIBusy {
+		return true
+	}
+	apiErr, isAPIErr := err.(mwclient.APIError)
+	return isAPIErr && apiErr.Code == "ratelimited"
+}
+
+// sendRate returns the number of talk page messages or emails we send each minute.
+func sendRate() float64 {
+	if yapperconfig.Config.SendRate > 0 {
+		return yapperconfig.Config.SendRate
+	}
+	return defaultSendRate
+}
+
+// sendBurst returns how many talk page messages or emails we can send at once before the rate kicks in.
+func sendBurst() int {
+	if yapperconfig.Config.SendBurst > 0 {
+		return yapperconfig.Config.SendBurst
+	}
+	return defaultSendBurst
+}
+
+// sendWorkers returns how many users we send messages to concurrently.
+func sendWorkers() int {
+	if yapperconfig.Config.SendWorkers > 0 {
+		return yapperconfig.Config.SendWorkers
+	}
+	return defaultSendWorkers
+}
@@ -0,0 +1,110 @@
+//go:build fakewiki
+// +build fakewiki
+
+package messages
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These are behind the fakewiki build tag because this package needs the ybtools config files
+// to load, so they're run by the e2e package, which provides them.
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"yapperbot-frs/src/ratelimit"
+	"yapperbot-frs/src/wiki"
+
+	"cgt.name/pkg/go-mwclient"
+)
+
+// throttledChannel is a deliveryChannel that fails with each of its errors in turn, and then succeeds.
+type throttledChannel struct {
+	errs     []error
+	attempts int
+}
+
+func (c *throttledChannel) name() string {
+	return "throttled"
+}
+
+func (c *throttledChannel) deliver(w wiki.Wiki, user string, messages []*Message) error {
+	c.attempts++
+	if c.attempts <= len(c.errs) {
+		return c.errs[c.attempts-1]
+	}
+	return nil
+}
+
+func (c *throttledChannel) fallback() deliveryChannel {
+	return nil
+}
+
+// pauses runs deliverPaced through channel on a fake clock, and returns every wait of a second or more
+// it made, which are the pauses, along with what it returned. The waits for the bucket to refill are
+// shorter than that at the rate used.
+func pauses(t *testing.T, channel deliveryChannel) ([]time.Duration, error) {
+	current := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	var waits []time.Duration
+	ratelimit.SetTime(func() time.Time { return current }, func(d time.Duration) {
+		current = current.Add(d)
+		if d >= time.Second {
+			waits = append(waits, d)
+		}
+	})
+	t.Cleanup(func() { ratelimit.SetTime(time.Now, time.Sleep) })
+
+	err := deliverPaced(wiki.NewFakeWiki(), ratelimit.NewBucket(600, 1), channel, "Example user", nil)
+	return waits, err
+}
+
+func TestDeliverPacedBacksOff(t *testing.T) {
+	ratelimited := mwclient.APIError{Code: "ratelimited", Info: "You've exceeded your rate limit."}
+	channel := &throttledChannel{errs: []error{ratelimited, mwclient.ErrAPIBusy, ratelimited}}
+
+	waits, err := pauses(t, channel)
+	if err != nil {
+		t.Errorf("got error %v, want the delivery to succeed once the servers stop throttling", err)
+	}
+	if want := []time.Duration{30 * time.Second, 60 * time.Second, 120 * time.Second}; !reflect.DeepEqual(waits, want) {
+		t.Errorf("paused for %v, want the pause doubling each time, %v", waits, want)
+	}
+}
+
+func TestDeliverPacedGivesUp(t *testing.T) {
+	ratelimited := mwclient.APIError{Code: "ratelimited", Info: "You've exceeded your rate limit."}
+	channel := &throttledChannel{errs: []error{ratelimited, ratelimited, ratelimited, ratelimited, ratelimited, ratelimited}}
+
+	waits, err := pauses(t, channel)
+	if err != ratelimited || channel.attempts != maxThrottleRetries+1 {
+		t.Errorf("got error %v after %d attempts, want it to give up after %d retries", err, channel.attempts, maxThrottleRetries)
+	}
+	if len(waits) != maxThrottleRetries || waits[len(waits)-1] != throttleBaseDelay<<uint(maxThrottleRetries-1) {
+		t.Errorf("paused for %v, want %d doubling pauses", waits, maxThrottleRetries)
+	}
+}
+
+func TestDeliverPacedDoesNotRetryOtherErrors(t *testing.T) {
+	protected := mwclient.APIError{Code: "protectedpage", Info: "This page has been protected."}
+	channel := &throttledChannel{errs: []error{protected}}
+
+	if waits, err := pauses(t, channel); err != protected || channel.attempts != 1 || len(waits) != 0 {
+		t.Errorf("got error %v after %d attempts and pauses %v, want it returned straight away", err, channel.attempts, waits)
+	}
+}
@@ -0,0 +1,143 @@
+package messages
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"strconv"
+	"strings"
+	"yapperbot-frs/src/frslist"
+	"yapperbot-frs/src/wiki"
+)
+
+// talkPageDelivery is the deliveryChannel that leaves messages in a new section on the user's talk page.
+type talkPageDelivery struct{}
+
+// name returns the name of the channel.
+func (talkPageDelivery) name() string {
+	return frslist.DeliveryTalkPage
+}
+
+// fallback returns nil, as there's nowhere else to go if we can't leave a talk page message.
+func (talkPageDelivery) fallback() deliveryChannel {
+	return nil
+}
+
+// deliver leaves a single {{FRS notification}} in a new section on the user's talk page,
+// covering all of their messages, with an edit summary listing what they're about.
+func (talkPageDelivery) deliver(w wiki.Wiki, user string, messages []*Message) error {
+	var textBuilder strings.Builder
+
+	// headersInSummary is just used to make sure our edit summary only has each header once.
+	// it maps each header for the summary to a number of times the header has been used.
+	// each header should be stored against its ''cleaned'' key, not its internal name.
+	var headersInSummary = map[string]*headerForMessageSending{}
+
+	textBuilder.WriteString("{{subst:")
+	textBuilder.WriteString(renderTemplate(notificationTemplate, notificationData{}))
+
+	for index, message := range messages {
+		strindex := strconv.Itoa(index)
+		cleanedHeader := cleanedHeaders[message.User.Header]
+		numberedParamToBuilder(&textBuilder, strindex, "title")
+		textBuilder.WriteString(message.Title)
+		numberedParamToBuilder(&textBuilder, strindex, "header")
+		textBuilder.WriteString(cleanedHeader)
+		numberedParamToBuilder(&textBuilder, strindex, "type")
+		textBuilder.WriteString(message.Type)
+		if message.RFCID != "" {
+			numberedParamToBuilder(&textBuilder, strindex, "rfcid")
+			textBuilder.WriteString(message.RFCID)
+		}
+		if message.Question != "" {
+			numberedParamToBuilder(&textBuilder, strindex, "question")
+			textBuilder.WriteString(escapeParamValue(message.Question))
+		}
+
+		if header, ok := headersInSummary[cleanedHeader]; ok {
+			// we already have the header in the list. use it.
+			header.countThisRun++
+		} else {
+			// the header hasn't yet been used, create it
+			headersInSummary[cleanedHeader] = &headerForMessageSending{
+				countThisRun: 1,
+				user:         message.User,
+				headerType:   message.Type,
+				title:        message.Title,
+			}
+		}
+	}
+
+	textBuilder.WriteString("}} ~~~~")
+	var notificationText string = textBuilder.String()
+
+	var summarySentListBuilder strings.Builder
+	var index int
+	for headerName, header := range headersInSummary {
+		var limitsummary string
+		totalLimit, totalLimited := header.user.GetTotalLimit()
+		limits := limitData{
+			Count:      header.user.GetCount(),
+			Limit:      header.user.Limit,
+			TotalCount: header.user.GetTotalCount(),
+			TotalLimit: totalLimit,
+			Period:     frslist.LimitPeriod(),
+		}
+		switch {
+		case header.user.Limited && totalLimited:
+			limitsummary = renderTemplate(limitAndTotalTemplate, limits)
+		case header.user.Limited:
+			limitsummary = renderTemplate(limitTemplate, limits)
+		case totalLimited:
+			limitsummary = renderTemplate(totalLimitTemplate, limits)
+		}
+
+		determiner := "a"
+		if header.countThisRun > 1 {
+			determiner = "some"
+			header.headerType = pluralizer.Plural(header.headerType)
+			// the title's only for a single message
+			header.title = ""
+		}
+
+		summarySentListBuilder.WriteString(renderTemplate(editSummaryItemTemplate, summaryItemData{
+			Determiner: determiner,
+			Header:     headerName,
+			Type:       header.headerType,
+			Title:      header.title,
+			Count:      int(header.countThisRun),
+			Limit:      limitsummary,
+		}))
+
+		// the list is of headers, not messages, as each header is only listed once
+		if len(headersInSummary) > 1 && index != len(headersInSummary)-1 {
+			summarySentListBuilder.WriteString(", ")
+			if index == len(headersInSummary)-2 {
+				// penultimate
+				summarySentListBuilder.WriteString("and ")
+			}
+		}
+		index++
+	}
+
+	// Generate the edit summary, with their limit
+	editsummary := renderTemplate(editSummaryTemplate, summaryData{Items: summarySentListBuilder.String()})
+
+	// Drop a note on each user's talk page inviting them to participate
+	return w.NewSection("User talk:"+user, messageTitle(messages), editsummary, notificationText)
+}
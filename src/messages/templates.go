@@ -0,0 +1,201 @@
+package messages
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"text/template"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// The keys of each message template, as used on the templates config page.
+const (
+	sectionTitleTemplate         string = "sectiontitle"
+	sectionTitleMultipleTemplate string = "sectiontitlemultiple"
+	editSummaryTemplate          string = "editsummary"
+	editSummaryItemTemplate      string = "editsummaryitem"
+	limitTemplate                string = "limit"
+	limitAndTotalTemplate        string = "limitandtotal"
+	totalLimitTemplate           string = "totallimit"
+	notificationTemplate         string = "notificationtemplate"
+	emailIntroTemplate           string = "emailintro"
+	emailItemTemplate            string = "emailitem"
+	emailFooterTemplate          string = "emailfooter"
+)
+
+// templateLeftDelim and templateRightDelim are the delimiters for template actions. They're
+// not the usual {{ and }}, as those mean something else entirely in wikitext.
+const templateLeftDelim string = "{%"
+const templateRightDelim string = "%}"
+
+// titleData is passed to the sectiontitle and sectiontitlemultiple templates, which are used for
+// both talk page section titles and email subjects. Header, Type and Title are only set for a
+// single message; Title is the title of the page the message is about.
+type titleData struct {
+	Header string
+	Type   string
+	Title  string
+	Count  int
+}
+
+// summaryData is passed to the editsummary template. Items is the list of rendered
+// editsummaryitem templates, one for each header, joined into an English list.
+type summaryData struct {
+	Items string
+}
+
+// summaryItemData is passed to the editsummaryitem template. Determiner is "a" or "some",
+// depending on Count, and Type is pluralised to match. Title is the title of the page the
+// message is about, and is only set if Count is 1. Limit is the rendered limit,
+// limitandtotal or totallimit template, or empty string if the user has no limits.
+type summaryItemData struct {
+	Determiner string
+	Header     string
+	Type       string
+	Title      string
+	Count      int
+	Limit      string
+}
+
+// limitData is passed to the limit, limitandtotal and totallimit templates. Count and Limit
+// are for the header, and TotalCount and TotalLimit are overall. Period describes the period
+// the limits apply over, such as "this month".
+type limitData struct {
+	Count      uint16
+	Limit      uint16
+	TotalCount uint16
+	TotalLimit uint16
+	Period     string
+}
+
+// notificationData is passed to the notificationtemplate template, which should
+// just give the name of the on-wiki template that's substituted into talk page messages.
+type notificationData struct{}
+
+// emailIntroData is passed to the emailintro template, which starts every email. User is the
+// name of the user the email is to.
+type emailIntroData struct {
+	User string
+}
+
+// emailItemData is passed to the emailitem template, which is rendered once for each message in
+// an email. Question is the plain text RfC question, if there is one, and Link is the URL of the
+// page, or empty string if there's no article path configured to build it with.
+type emailItemData struct {
+	Header   string
+	Type     string
+	Title    string
+	Question string
+	Link     string
+}
+
+// emailFooterData is passed to the emailfooter template, which ends every email, explaining why
+// it was sent. FRSLink is the URL of the FRS list, or its title if there's no article path configured.
+type emailFooterData struct {
+	FRSLink string
+}
+
+// defaultTemplates are the templates used for any key not set on the templates config page,
+// along with an example of the data passed to each, used to validate them.
+var defaultTemplates = map[string]struct {
+	text    string
+	example interface{}
+}{
+	sectionTitleTemplate:         {`Feedback request: {%.Header%} {%.Type%}`, titleData{}},
+	sectionTitleMultipleTemplate: {`Feedback requests from the Feedback Request Service`, titleData{}},
+	editSummaryTemplate:          {`[[WP:FRS|Feedback Request Service]] notification on {%.Items%}. You can unsubscribe at [[WP:FRS]].`, summaryData{}},
+	editSummaryItemTemplate:      {`{%.Determiner%} "{%.Header%}" {%.Type%}{%.Limit%}`, summaryItemData{}},
+	limitTemplate:                {` ({%.Count%}/{%.Limit%} {%.Period%})`, limitData{}},
+	limitAndTotalTemplate:        {` ({%.Count%}/{%.Limit%} {%.Period%}, {%.TotalCount%}/{%.TotalLimit%} overall)`, limitData{}},
+	totalLimitTemplate:           {` ({%.TotalCount%}/{%.TotalLimit%} overall {%.Period%})`, limitData{}},
+	notificationTemplate:         {`FRS notification`, notificationData{}},
+	emailIntroTemplate:           {"Hello {%.User%},\n\nThe Feedback Request Service would like your feedback on the following:\n", emailIntroData{}},
+	emailItemTemplate:            {"\n* {%.Title%}: {%.Type%}, from \"{%.Header%}\"\n{%if .Question%}  {%.Question%}\n{%end%}{%if .Link%}  {%.Link%}\n{%end%}", emailItemData{}},
+	emailFooterTemplate:          {"\nYou're getting these by email because you set delivery=email on your Feedback Request Service subscriptions. To get them on your talk page instead, or to unsubscribe, edit your entries at {%.FRSLink%}.", emailFooterData{}},
+}
+
+// templates holds the parsed template for every key, from the config page or the defaults.
+var templates = map[string]*template.Template{}
+
+func init() {
+	for key, def := range defaultTemplates {
+		templates[key] = template.Must(parseTemplate(key, def.text))
+	}
+}
+
+// LoadTemplates loads the message templates from the JSON page configured as messagetemplatespageid,
+// in the form {"sectiontitle": "Feedback request: {%.Header%} {%.Type%}", ...}. Each template is
+// checked as it's loaded; any that are invalid, or aren't on the page, keep their defaults.
+// If there's no page configured, the defaults are used for everything.
+func LoadTemplates(w wiki.Wiki) {
+	if yapperconfig.Config.MessageTemplatesPageID == "" {
+		return
+	}
+
+	text, err := w.FetchWikitext(yapperconfig.Config.MessageTemplatesPageID)
+	if err != nil {
+		log.Println("WARNING: Failed to fetch the message templates page, so using the default templates. The error was", err)
+		return
+	}
+	var configured map[string]string
+	if err := json.Unmarshal([]byte(text), &configured); err != nil {
+		log.Println("WARNING: The message templates page isn't valid JSON, so using the default templates. The error was", err)
+		return
+	}
+
+	for key, text := range configured {
+		def, known := defaultTemplates[key]
+		if !known {
+			log.Println("WARNING: Unknown message template", key, "on the message templates page, so ignoring it")
+			continue
+		}
+
+		parsed, err := parseTemplate(key, text)
+		if err == nil {
+			// make sure the template only uses fields that exist, so it won't fail when we come to use it
+			err = parsed.Execute(&strings.Builder{}, def.example)
+		}
+		if err != nil {
+			log.Println("WARNING: Message template", key, "is invalid, so using the default for it. The error was", err)
+			continue
+		}
+		templates[key] = parsed
+	}
+	log.Println("Loaded message templates from the message templates page")
+}
+
+// parseTemplate parses a message template with our delimiters.
+func parseTemplate(key, text string) (*template.Template, error) {
+	return template.New(key).Delims(templateLeftDelim, templateRightDelim).Option("missingkey=error").Parse(text)
+}
+
+// renderTemplate renders the message template for key with the given data. Templates are checked
+// when they're loaded, so this shouldn't fail, but if it does, the default is used instead.
+func renderTemplate(key string, data interface{}) string {
+	var rendered strings.Builder
+	if err := templates[key].Execute(&rendered, data); err != nil {
+		log.Println("WARNING: Message template", key, "failed with error", err, "so using the default for it")
+		rendered.Reset()
+		template.Must(parseTemplate(key, defaultTemplates[key].text)).Execute(&rendered, data)
+	}
+	return rendered.String()
+}
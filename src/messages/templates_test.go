@@ -0,0 +1,134 @@
+//go:build fakewiki
+// +build fakewiki
+
+package messages
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These are behind the fakewiki build tag because this package needs the ybtools config files
+// to load, so they're run by the e2e package, which provides them.
+
+import (
+	"testing"
+	"text/template"
+	"yapperbot-frs/src/frslist"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+)
+
+// templateTestMessages returns two messages to Example user, one about an RfC and one about a GA nomination.
+func templateTestMessages() []*Message {
+	CleanHeader("<!--rfc:bio-->Biographies")
+	CleanHeader("<!--gan-->Geography and places")
+	return []*Message{
+		{
+			User:     &frslist.FRSUser{Username: "Example user", Header: "<!--rfc:bio-->Biographies"},
+			Type:     "request for comment",
+			Title:    "Talk:Example biography",
+			RFCID:    "ABCDEF1",
+			Question: "Should the article say more?",
+		},
+		{
+			User:  &frslist.FRSUser{Username: "Example user", Header: "<!--gan-->Geography and places"},
+			Type:  "Good Article nomination",
+			Title: "Talk:Example river",
+		},
+	}
+}
+
+// loadTestTemplates loads the message templates from a templates page holding text, restoring the
+// config and templates when the test finishes.
+func loadTestTemplates(t *testing.T, text string) {
+	oldConfig := yapperconfig.Config
+	oldTemplates := templates
+	t.Cleanup(func() {
+		yapperconfig.Config = oldConfig
+		templates = oldTemplates
+	})
+	templates = map[string]*template.Template{}
+	for key, template := range oldTemplates {
+		templates[key] = template
+	}
+
+	fake := wiki.NewFakeWiki()
+	fake.AddPage(wiki.FakePage{ID: "1", Title: "User:FRS test/templates.json", Content: text})
+	yapperconfig.Config.MessageTemplatesPageID = "1"
+	LoadTemplates(fake)
+}
+
+func TestEmailTextDefault(t *testing.T) {
+	oldConfig := yapperconfig.Config
+	t.Cleanup(func() { yapperconfig.Config = oldConfig })
+	yapperconfig.Config.ArticlePath = "https://en.wikipedia.org/wiki/"
+
+	want := "Hello Example user,\n\n" +
+		"The Feedback Request Service would like your feedback on the following:\n" +
+		"\n* Talk:Example biography: request for comment, from \"Biographies\"\n" +
+		"  Should the article say more?\n" +
+		"  https://en.wikipedia.org/wiki/Talk:Example_biography#rfc_ABCDEF1\n" +
+		"\n* Talk:Example river: Good Article nomination, from \"Geography and places\"\n" +
+		"  https://en.wikipedia.org/wiki/Talk:Example_river\n" +
+		"\nYou're getting these by email because you set delivery=email on your Feedback Request Service subscriptions. " +
+		"To get them on your talk page instead, or to unsubscribe, edit your entries at https://en.wikipedia.org/wiki/Wikipedia:Feedback_request_service."
+	if text := emailText("Example user", templateTestMessages()); text != want {
+		t.Errorf("got email text %q, want %q", text, want)
+	}
+}
+
+func TestEmailTemplatesFromPage(t *testing.T) {
+	loadTestTemplates(t, `{
+		"emailintro": "Hi {%.User%}!\n",
+		"emailitem": "- {%.Title%} ({%.Header%}){%if .Question%}: {%.Question%}{%end%}\n",
+		"emailfooter": "Unsubscribe at {%.FRSLink%}"
+	}`)
+
+	want := "Hi Example user!\n" +
+		"- Talk:Example biography (Biographies): Should the article say more?\n" +
+		"- Talk:Example river (Geography and places)\n" +
+		"Unsubscribe at Wikipedia:Feedback request service"
+	if text := emailText("Example user", templateTestMessages()); text != want {
+		t.Errorf("got email text %q, want %q", text, want)
+	}
+}
+
+func TestTitleInTemplates(t *testing.T) {
+	loadTestTemplates(t, `{
+		"sectiontitle": "Feedback wanted at {%.Title%}",
+		"editsummary": "{%.Items%}",
+		"editsummaryitem": "{%.Header%}{%if .Title%}: {%.Title%}{%end%}"
+	}`)
+
+	fake := wiki.NewFakeWiki()
+	messages := templateTestMessages()
+	if err := (talkPageDelivery{}).deliver(fake, "Example user", messages[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Edits) != 1 || fake.Edits[0].SectionTitle != "Feedback wanted at Talk:Example biography" || fake.Edits[0].Summary != "Biographies: Talk:Example biography" {
+		t.Errorf("got edits %+v, want one titled and summarised with the page title", fake.Edits)
+	}
+
+	second := *messages[0]
+	second.Title = "Talk:Example autobiography"
+	if err := (talkPageDelivery{}).deliver(fake, "Example user", []*Message{messages[0], &second}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Edits) != 2 || fake.Edits[1].Summary != "Biographies" {
+		t.Errorf("got edits %+v, want the second summarised without a title, as it's about two pages", fake.Edits)
+	}
+}
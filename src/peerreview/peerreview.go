@@ -0,0 +1,82 @@
+package peerreview
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"regexp"
+	"strings"
+)
+
+// prPrefixRegex is a regex that matches the comments at the start of each peer review header;
+// these are in the form <!--pr:topic-->, where topic is the topic code used in {{Peer review}}.
+var prPrefixRegex *regexp.Regexp
+
+const requestType string = "peer review"
+
+// Nom represents a peer review request, which has a single topic only.
+type Nom struct {
+	Topic   string
+	Article string
+}
+
+// knownTopics are the topic codes that can be given to {{Peer review}}.
+var knownTopics = map[string]bool{
+	"arts": true, "langlit": true, "philrelig": true, "everydaylife": true, "socsci": true, "lifesci": true,
+	"natsci": true, "math": true, "engtech": true, "geography": true, "history": true, "general": true,
+}
+
+func init() {
+	prPrefixRegex = regexp.MustCompile(`<!--pr:(\w*?)-->`)
+}
+
+// IncludeHeader determines if a given FRS header corresponds to this item correctly
+// Takes a string of the entire header (minus the === bits) and returns a bool for
+// if the header is included, and separately a bool indicating whether the header is the all
+// header or not
+func (n Nom) IncludeHeader(header string) (bool, bool) {
+	matches := prPrefixRegex.FindStringSubmatch(header)
+	if matches == nil {
+		// no matches means it's not a peer review header
+		return false, false
+	}
+
+	// check for special keyword "all"
+	if matches[1] == "all" {
+		return true, true
+	}
+	// topic codes on the template aren't always written in the same case
+	return n.Topic != "" && strings.EqualFold(matches[1], n.Topic), false
+}
+
+// KnownHeader returns whether a FRS header is for peer reviews in a topic that exists,
+// or for all peer reviews.
+func KnownHeader(header string) bool {
+	matches := prPrefixRegex.FindStringSubmatch(header)
+	return matches != nil && (matches[1] == "all" || knownTopics[strings.ToLower(matches[1])])
+}
+
+// PageTitle is a simple getter for the article up for peer review in order to make the interface work
+func (n Nom) PageTitle() string {
+	return n.Article
+}
+
+// RequestType returns the type this is - a peer review - so that it can be used in a template
+func (n Nom) RequestType() string {
+	return requestType
+}
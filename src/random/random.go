@@ -0,0 +1,60 @@
+package random
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// generator is the random number generator used for everything random in the FRS,
+// so that a whole run can be reproduced by seeding it with Seed.
+var generator = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// generatorMux guards generator, as a rand.Rand isn't safe for concurrent use.
+var generatorMux sync.Mutex
+
+// Seed seeds the random number generator, so that the same inputs will always give the same results.
+func Seed(seed int64) {
+	generatorMux.Lock()
+	defer generatorMux.Unlock()
+	generator.Seed(seed)
+}
+
+// Intn returns a random number in [0, n).
+func Intn(n int) int {
+	generatorMux.Lock()
+	defer generatorMux.Unlock()
+	return generator.Intn(n)
+}
+
+// Float64 returns a random number in [0.0, 1.0).
+func Float64() float64 {
+	generatorMux.Lock()
+	defer generatorMux.Unlock()
+	return generator.Float64()
+}
+
+// Shuffle randomly shuffles n elements, using swap to swap the elements with indexes i and j.
+func Shuffle(n int, swap func(i, j int)) {
+	generatorMux.Lock()
+	defer generatorMux.Unlock()
+	generator.Shuffle(n, swap)
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"sync"
+	"time"
+)
+
+// A Bucket is a token bucket rate limiter, safe for use from multiple goroutines.
+// It holds up to burst tokens, and is refilled at a steady rate; each request takes
+// a token, waiting for one to be refilled if the bucket is empty.
+//
+// Unlike everything else in the FRS, the bucket runs on real time, rather than the
+// clock package's - it's pacing real requests, so it can't be fooled by a fixed clock.
+// It can only be replaced with SetTime, for testing.
+type Bucket struct {
+	mu sync.Mutex
+	// perSecond is the rate the bucket refills at, in tokens per second.
+	perSecond float64
+	burst     float64
+	tokens    float64
+	// last is when we last worked out how many tokens were in the bucket.
+	last time.Time
+	// pausedUntil is when the bucket starts refilling again after a PauseFor.
+	pausedUntil time.Time
+}
+
+// now and sleep are where buckets get the time from and how they wait. They're time.Now and
+// time.Sleep, unless they've been replaced with SetTime.
+var now = time.Now
+var sleep = time.Sleep
+
+// SetTime replaces how buckets get the current time and wait, so that they can be tested
+// without really waiting. It should be called before any buckets are made.
+func SetTime(nowFunc func() time.Time, sleepFunc func(time.Duration)) {
+	now = nowFunc
+	sleep = sleepFunc
+}
+
+// NewBucket takes a rate in requests per minute and the number of requests
+// that can be made at once, and returns a full bucket.
+func NewBucket(perMinute float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{
+		perSecond: perMinute / 60,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      now(),
+	}
+}
+
+// Wait blocks until a token is available, and takes it.
+func (b *Bucket) Wait() {
+	for {
+		wait := b.take()
+		if wait <= 0 {
+			return
+		}
+		sleep(wait)
+	}
+}
+
+// PauseFor empties the bucket, and stops it refilling for the given duration; it's used to back
+// off when the server tells us to slow down. If the bucket is already paused for longer, it stays so.
+func (b *Bucket) PauseFor(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	until := now().Add(d)
+	if until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+	b.tokens = 0
+	b.last = b.pausedUntil
+}
+
+// take takes a token if there's one available, returning zero; otherwise, it returns
+// how long to wait before trying again.
+func (b *Bucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := now()
+	if current.Before(b.pausedUntil) {
+		return b.pausedUntil.Sub(current)
+	}
+
+	if current.After(b.last) {
+		b.tokens += current.Sub(b.last).Seconds() * b.perSecond
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = current
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.perSecond * float64(time.Second))
+}
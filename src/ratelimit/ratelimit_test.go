@@ -0,0 +1,117 @@
+package ratelimit
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeTime is a clock for buckets that only moves when they sleep, recording how long each sleep was.
+type fakeTime struct {
+	current time.Time
+	sleeps  []time.Duration
+}
+
+func (f *fakeTime) now() time.Time {
+	return f.current
+}
+
+func (f *fakeTime) sleep(d time.Duration) {
+	f.sleeps = append(f.sleeps, d)
+	f.current = f.current.Add(d)
+}
+
+// useFakeTime runs buckets on a new fakeTime for the rest of the test.
+func useFakeTime(t *testing.T) *fakeTime {
+	fake := &fakeTime{current: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)}
+	SetTime(fake.now, fake.sleep)
+	t.Cleanup(func() { SetTime(time.Now, time.Sleep) })
+	return fake
+}
+
+// elapsed returns how long the fake clock has moved on since it started.
+func (f *fakeTime) elapsed() time.Duration {
+	var total time.Duration
+	for _, d := range f.sleeps {
+		total += d
+	}
+	return total
+}
+
+func TestBucketBurst(t *testing.T) {
+	fake := useFakeTime(t)
+	bucket := NewBucket(6, 3)
+
+	for i := 0; i < 3; i++ {
+		bucket.Wait()
+	}
+	if len(fake.sleeps) != 0 {
+		t.Errorf("slept for %v within the burst, want no waiting", fake.sleeps)
+	}
+
+	// six a minute is one every ten seconds
+	bucket.Wait()
+	if fake.elapsed() != 10*time.Second {
+		t.Errorf("waited %v once the burst was used up, want 10s", fake.elapsed())
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	fake := useFakeTime(t)
+	bucket := NewBucket(6, 3)
+	for i := 0; i < 3; i++ {
+		bucket.Wait()
+	}
+
+	// after 25 seconds, two tokens have refilled, and half of a third
+	fake.current = fake.current.Add(25 * time.Second)
+	bucket.Wait()
+	bucket.Wait()
+	if len(fake.sleeps) != 0 {
+		t.Errorf("slept for %v with tokens refilled, want no waiting", fake.sleeps)
+	}
+	bucket.Wait()
+	if fake.elapsed() != 5*time.Second {
+		t.Errorf("waited %v for the half-refilled token, want 5s", fake.elapsed())
+	}
+
+	// however long it's left, it never holds more than the burst
+	fake.current = fake.current.Add(time.Hour)
+	for i := 0; i < 4; i++ {
+		bucket.Wait()
+	}
+	if fake.elapsed() != 15*time.Second {
+		t.Errorf("waited %v in total, want the fourth request after an hour to wait 10s", fake.elapsed())
+	}
+}
+
+func TestBucketPause(t *testing.T) {
+	fake := useFakeTime(t)
+	bucket := NewBucket(6, 3)
+
+	// pausing empties the bucket, and nothing refills until the pause is over
+	bucket.PauseFor(30 * time.Second)
+	// a shorter pause doesn't cut a longer one short
+	bucket.PauseFor(10 * time.Second)
+	bucket.Wait()
+	if fake.elapsed() != 40*time.Second {
+		t.Errorf("waited %v after pausing for 30s, want 30s for the pause and 10s for a token", fake.elapsed())
+	}
+}
@@ -0,0 +1,248 @@
+package report
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+	"yapperbot-frs/src/clock"
+
+	"github.com/mashedkeyboard/ybtools/v2"
+)
+
+// A Request identifies a single request for feedback in the report.
+type Request struct {
+	Title string `json:"title"`
+	Type  string `json:"type"`
+}
+
+// A SelectedUser is a user picked to receive a message, along with the header they were picked from.
+type SelectedUser struct {
+	Username string `json:"username"`
+	Header   string `json:"header"`
+}
+
+// A Selection records the users that were picked to receive messages about a request.
+type Selection struct {
+	Request
+	Users []SelectedUser `json:"users"`
+}
+
+// An Exclusion records a user left out of the run, and why.
+type Exclusion struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+}
+
+// A Delivery records the outcome of sending a user their messages.
+// Code is the API error code, if sending failed with one.
+type Delivery struct {
+	Username string `json:"username"`
+	Messages int    `json:"messages"`
+	// Channel is how the messages were delivered; it's only set for messages that were sent.
+	Channel string `json:"channel,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// A Retry records a message that failed to send and was put on the retry queue, or
+// that was dropped from it. NextAttempt is only set for queued messages, and Reason
+// only for dropped ones.
+type Retry struct {
+	Request
+	Username    string `json:"username"`
+	Attempts    int    `json:"attempts"`
+	NextAttempt string `json:"nextattempt,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// A Held records a message held to be sent in a user's digest, rather than straight away.
+type Held struct {
+	Request
+	Username string `json:"username"`
+	Digest   string `json:"digest"`
+}
+
+// runReport is the structure of the JSON report written at the end of each run.
+type runReport struct {
+	Started  string `json:"started"`
+	Finished string `json:"finished"`
+	DryRun   bool   `json:"dryrun"`
+	// Seed is what the random number generator was seeded with, so the run can be reproduced.
+	Seed int64 `json:"seed"`
+	// PagesScanned maps each request source to the number of pages it looked at.
+	PagesScanned map[string]int `json:"pagesscanned"`
+	// RfCsSkippedNoID lists the pages holding RfCs skipped because Legobot hadn't given them an ID yet.
+	RfCsSkippedNoID []string `json:"rfcsskippednoid"`
+	// NoMatchingHeaders lists the requests that didn't match any header on the FRS list.
+	NoMatchingHeaders []Request `json:"nomatchingheaders"`
+	// ExcludedUsers lists the users who weren't considered for any messages, as they're blocked,
+	// locked or inactive.
+	ExcludedUsers  []Exclusion `json:"excludedusers"`
+	Selections     []Selection `json:"selections"`
+	MessagesSent   []Delivery  `json:"messagessent"`
+	MessagesFailed []Delivery  `json:"messagesfailed"`
+	// RetriesQueued lists the messages put on the retry queue after failing to send this run,
+	// and MessagesDropped those given up on for good.
+	RetriesQueued   []Retry `json:"retriesqueued"`
+	MessagesDropped []Retry `json:"messagesdropped"`
+	// HeldForDigest lists the messages queued this run that were held for users' digests.
+	HeldForDigest []Held `json:"heldfordigest"`
+	// SentCountDeltas maps headers down to users, and then users down to how much their
+	// sent count changed over the run.
+	SentCountDeltas map[string]map[string]int `json:"sentcountdeltas"`
+}
+
+// current is the report for this run.
+var current = runReport{
+	PagesScanned:      map[string]int{},
+	RfCsSkippedNoID:   []string{},
+	NoMatchingHeaders: []Request{},
+	ExcludedUsers:     []Exclusion{},
+	Selections:        []Selection{},
+	MessagesSent:      []Delivery{},
+	MessagesFailed:    []Delivery{},
+	RetriesQueued:     []Retry{},
+	MessagesDropped:   []Retry{},
+	HeldForDigest:     []Held{},
+	SentCountDeltas:   map[string]map[string]int{},
+}
+
+// currentMux guards current, as messages may be reported from more than one goroutine.
+var currentMux sync.Mutex
+
+// Start marks the start of the run in the report.
+func Start(dryRun bool) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.Started = clock.Now().Format(time.RFC3339)
+	current.DryRun = dryRun
+}
+
+// Seed records what the random number generator was seeded with.
+func Seed(seed int64) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.Seed = seed
+}
+
+// PagesScanned records that a request source looked at n pages.
+func PagesScanned(source string, n int) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.PagesScanned[source] += n
+}
+
+// RfCSkippedNoID records that an RfC on the given page was skipped as it had no ID yet.
+func RfCSkippedNoID(title string) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.RfCsSkippedNoID = append(current.RfCsSkippedNoID, title)
+}
+
+// NoMatchingHeaders records that a request didn't match any header on the FRS list.
+func NoMatchingHeaders(title, requestType string) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.NoMatchingHeaders = append(current.NoMatchingHeaders, Request{Title: title, Type: requestType})
+}
+
+// UserExcluded records that a user wasn't considered for any messages this run, and why.
+func UserExcluded(username, reason string) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.ExcludedUsers = append(current.ExcludedUsers, Exclusion{Username: username, Reason: reason})
+}
+
+// UsersSelected records the users picked to receive messages about a request.
+func UsersSelected(title, requestType string, users []SelectedUser) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.Selections = append(current.Selections, Selection{Request: Request{Title: title, Type: requestType}, Users: users})
+}
+
+// MessagesSent records that a user was successfully sent n messages through the given channel.
+func MessagesSent(username string, n int, channel string) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.MessagesSent = append(current.MessagesSent, Delivery{Username: username, Messages: n, Channel: channel})
+}
+
+// MessagesFailed records that sending n messages to a user failed, with the API error code if there was one.
+func MessagesFailed(username string, n int, code string, err error) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.MessagesFailed = append(current.MessagesFailed, Delivery{Username: username, Messages: n, Code: code, Error: err.Error()})
+}
+
+// RetryQueued records that a message failed to send, and was put on the retry queue to be tried again.
+func RetryQueued(username, title, requestType string, attempts int, nextAttempt string) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.RetriesQueued = append(current.RetriesQueued, Retry{Request: Request{Title: title, Type: requestType}, Username: username, Attempts: attempts, NextAttempt: nextAttempt})
+}
+
+// MessageDropped records that a message was given up on, and why.
+func MessageDropped(username, title, requestType string, attempts int, reason string) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.MessagesDropped = append(current.MessagesDropped, Retry{Request: Request{Title: title, Type: requestType}, Username: username, Attempts: attempts, Reason: reason})
+}
+
+// MessageHeld records that a message was held to be sent in a user's digest.
+func MessageHeld(username, title, requestType, digest string) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.HeldForDigest = append(current.HeldForDigest, Held{Request: Request{Title: title, Type: requestType}, Username: username, Digest: digest})
+}
+
+// SentCountDelta records how much a user's sent count for a header changed over the run.
+func SentCountDelta(header, username string, delta int) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	if current.SentCountDeltas[header] == nil {
+		current.SentCountDeltas[header] = map[string]int{}
+	}
+	current.SentCountDeltas[header][username] = delta
+}
+
+// Write writes the report out as JSON to the given path. It's deferred from main,
+// so that we still get a report of what happened if the run dies part way through.
+func Write(path string) {
+	currentMux.Lock()
+	defer currentMux.Unlock()
+	current.Finished = clock.Now().Format(time.RFC3339)
+
+	// headers are full of HTML comments, so don't escape those, or the report gets hard to read
+	var reportJSON bytes.Buffer
+	encoder := json.NewEncoder(&reportJSON)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(current); err != nil {
+		ybtools.PanicErr("Failed to serialize run report with error ", err)
+	}
+	if err := ioutil.WriteFile(path, reportJSON.Bytes(), 0644); err != nil {
+		ybtools.PanicErr("Failed to write run report with error ", err)
+	}
+	log.Println("Wrote run report to", path)
+}
@@ -0,0 +1,98 @@
+package requestedmove
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"reflect"
+	"strings"
+	"yapperbot-frs/src/wiki"
+	"yapperbot-frs/src/yapperconfig"
+
+	"github.com/mashedkeyboard/ybtools/v2"
+)
+
+// movesDoneEditSummary is the edit summary used when saving the list of completed requested moves.
+const movesDoneEditSummary string = "Updating list of completed requested moves"
+
+// doneMoves maps found this session and completed/already-completed
+// move IDs to true (again, only used for o(n) lookups)
+var doneMoves map[string]bool = map[string]bool{}
+
+// loadedMoves maps *already-completed* move IDs to true.
+// It only contains moves that were in the JSON at the start.
+// We need this to be separate so we can keep ones out of doneMoves that aren't open anymore
+var loadedMoves map[string]bool = map[string]bool{}
+
+// MarkMovesDone takes a series of Move objects,
+// and adds the moves to the list of completed moves.
+func MarkMovesDone(movesDone []Move) {
+	for _, move := range movesDone {
+		doneMoves[move.ID] = true
+	}
+}
+
+// LoadMovesDone loads the requested moves that have already been marked as done into loadedMoves.
+// It needs to be called before the start of each session that includes a requested move lookup.
+func LoadMovesDone(w wiki.Wiki) {
+	movesDoneJSON := wiki.LoadJSONFromPageID(w, yapperconfig.Config.RMsDonePageID)
+	movesDoneList, err := movesDoneJSON.GetStringArray("rmsdone")
+	if err != nil {
+		ybtools.PanicErr("rmsdone not found in movesDoneJSON! the JSON looks corrupt.")
+	}
+	for _, moveID := range movesDoneList {
+		loadedMoves[moveID] = true
+	}
+}
+
+// AlreadyDone takes a move ID and returns whether it's already included in either
+// loadedMoves or doneMoves.
+func AlreadyDone(moveID string) bool {
+	if loadedMoves[moveID] {
+		return true
+	}
+	return doneMoves[moveID]
+}
+
+// SaveMovesDone takes a Wiki, and serializes
+// the doneMoves map, before saving it on-wiki.
+func SaveMovesDone(w wiki.Wiki) {
+	// Only update the list of moves done if it's actually changed -
+	// i.e. if the list of doneMoves is not deeply equal to the list of
+	// loadedMoves (bigger, smaller, changed in any way).
+	if !reflect.DeepEqual(doneMoves, loadedMoves) {
+		var movesDoneJSONBuilder strings.Builder
+		var movesDoneSlice []string = []string{}
+
+		for moveID := range doneMoves {
+			movesDoneSlice = append(movesDoneSlice, moveID)
+		}
+
+		movesDoneJSONBuilder.WriteString(yapperconfig.OpeningJSON)
+		movesDoneJSONBuilder.WriteString(`"rmsdone":`)
+		movesDoneJSONBuilder.WriteString(ybtools.SerializeToJSON(movesDoneSlice))
+		movesDoneJSONBuilder.WriteString(yapperconfig.ClosingJSON)
+
+		// Just like the RfCs, this must be kept valid and correct under all
+		// circumstances, to prevent us sending multiple messages.
+		err := w.EditPage(yapperconfig.Config.RMsDonePageID, movesDoneEditSummary, movesDoneJSONBuilder.String())
+		if err != nil {
+			ybtools.PanicErr("Failed to update requested moves page ", yapperconfig.Config.RMsDonePageID, " to list completed moves, with error ", err)
+		}
+	}
+}
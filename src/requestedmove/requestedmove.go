@@ -0,0 +1,99 @@
+package requestedmove
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// rmPrefix is the comment at the start of the requested moves header on the FRS list.
+const rmPrefix string = "<!--rm-->"
+
+const requestType string = "requested move"
+
+// idLength is the number of hex characters of the hash we use for each move's ID.
+const idLength int = 12
+
+// A Move represents a single requested move discussion. Unlike RfCs, requested moves
+// aren't given an ID by anyone else, so we generate one from the page the discussion
+// is on, the parameters of its {{Requested move/dated}} template, and when it was opened.
+type Move struct {
+	ID           string
+	FeedbackDone bool
+	PageHolding  string
+}
+
+// GenerateID takes the title of the page holding a move discussion, the parameters of its
+// {{Requested move/dated}} template, and the timestamp of the signature opening the discussion,
+// and returns an ID uniquely identifying the discussion. The timestamp tells apart discussions
+// proposing the same move on the same page at different times. Neither the parameters nor the
+// opening signature change when a discussion is relisted, so neither does the ID.
+//
+// Before the timestamp was included, IDs were generated without it, which is the same as
+// passing an empty timestamp; LegacyID does that, so that moves recorded as done under
+// those IDs can still be recognised.
+func GenerateID(title string, params []string, timestamp string) string {
+	hash := sha1.New()
+	hash.Write([]byte(title))
+	for _, param := range params {
+		// null bytes can't appear in titles or wikitext, so they're a safe separator
+		hash.Write([]byte{0})
+		hash.Write([]byte(strings.TrimSpace(param)))
+	}
+	if timestamp != "" {
+		// like null bytes, other control characters can't appear in wikitext, so separating the
+		// timestamp with one keeps it from being mistaken for a param
+		hash.Write([]byte{1})
+		hash.Write([]byte(timestamp))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:idLength]
+}
+
+// LegacyID takes the title of the page holding a move discussion and the parameters of its
+// {{Requested move/dated}} template, and returns the ID the discussion would have been given
+// before IDs included when it was opened.
+func LegacyID(title string, params []string) string {
+	return GenerateID(title, params, "")
+}
+
+// IncludeHeader determines if a given FRS header corresponds to this item correctly
+// Takes a string of the entire header (minus the === bits) and returns a bool for
+// if the header is included, and separately a bool indicating whether the header is the all
+// header or not. Requested moves have no topics, so every requested move header is included,
+// and none of them is treated as the all header.
+func (m Move) IncludeHeader(header string) (bool, bool) {
+	return strings.HasPrefix(header, rmPrefix), false
+}
+
+// KnownHeader returns whether a FRS header is for requested moves.
+func KnownHeader(header string) bool {
+	return strings.HasPrefix(header, rmPrefix)
+}
+
+// PageTitle is a simple getter for the PageHolding in order to make the interface work
+func (m Move) PageTitle() string {
+	return m.PageHolding
+}
+
+// RequestType returns the type this is - a requested move - so that it can be used in a template
+func (m Move) RequestType() string {
+	return requestType
+}
@@ -21,10 +21,9 @@ package rfc
 import (
 	"reflect"
 	"strings"
+	"yapperbot-frs/src/wiki"
 	"yapperbot-frs/src/yapperconfig"
 
-	"cgt.name/pkg/go-mwclient"
-	"cgt.name/pkg/go-mwclient/params"
 	"github.com/mashedkeyboard/ybtools/v2"
 )
 
@@ -37,6 +36,9 @@ var doneRfcs map[string]bool = map[string]bool{}
 // We need this to be separate so we can keep ones out of doneRfcs that aren't in the category anymore
 var loadedRfcs map[string]bool = map[string]bool{}
 
+// rfcsDoneEditSummary is the edit summary used when saving the list of completed RfCs.
+const rfcsDoneEditSummary string = "Updating list of completed RfCs"
+
 // MarkRfcsDone takes a series of RfC objects,
 // and adds the RfCs to the list of completed RfCs.
 func MarkRfcsDone(rfcsDone []RfC) {
@@ -47,8 +49,8 @@ func MarkRfcsDone(rfcsDone []RfC) {
 
 // LoadRfcsDone loads the RFCs that have already been marked as done into loadedRfcs.
 // It needs to be called before the start of each session that includes an RfC lookup.
-func LoadRfcsDone(w *mwclient.Client) {
-	rfcsDoneJSON := ybtools.LoadJSONFromPageID(yapperconfig.Config.RFCsDonePageID)
+func LoadRfcsDone(w wiki.Wiki) {
+	rfcsDoneJSON := wiki.LoadJSONFromPageID(w, yapperconfig.Config.RFCsDonePageID)
 	rfcsDoneList, err := rfcsDoneJSON.GetStringArray("rfcsdone")
 	if err != nil {
 		ybtools.PanicErr("rfcsdone not found in rfcsDoneJSON! the JSON looks corrupt.")
@@ -69,7 +71,7 @@ func AlreadyDone(rfcID string) bool {
 
 // SaveRfcsDone takes an mwclient, and serializes
 // the doneRfcs map, before saving it on-wiki.
-func SaveRfcsDone(w *mwclient.Client) {
+func SaveRfcsDone(w wiki.Wiki) {
 	// Only update the list of RfCs done if it's actually changed -
 	// i.e. if the list of doneRfcs is not deeply equal to the list of
 	// loadedRfcs (bigger, smaller, changed in any way).
@@ -86,20 +88,12 @@ func SaveRfcsDone(w *mwclient.Client) {
 		rfcsDoneJSONBuilder.WriteString(ybtools.SerializeToJSON(rfcsDoneSlice))
 		rfcsDoneJSONBuilder.WriteString(yapperconfig.ClosingJSON)
 
-		// Updating this list must be done under all circumstances; we cannot
-		// wait for maxlag here, it's important that this is kept valid and correct
+		// Updating this list must be done under all circumstances; EditPage doesn't
+		// wait for maxlag, as it's important that this is kept valid and correct
 		// to prevent us sending multiple messages.
-		ybtools.NoMaxlagDo(func() (err error) {
-			err = w.Edit(params.Values{
-				"pageid":  yapperconfig.Config.RFCsDonePageID,
-				"summary": "Updating list of completed RfCs",
-				"bot":     "true",
-				"text":    rfcsDoneJSONBuilder.String(),
-			})
-			if err != nil {
-				ybtools.PanicErr("Failed to update RfC page ", yapperconfig.Config.RFCsDonePageID, " to list completed RfCs, with error ", err)
-			}
-			return
-		}, w)
+		err := w.EditPage(yapperconfig.Config.RFCsDonePageID, rfcsDoneEditSummary, rfcsDoneJSONBuilder.String())
+		if err != nil {
+			ybtools.PanicErr("Failed to update RfC page ", yapperconfig.Config.RFCsDonePageID, " to list completed RfCs, with error ", err)
+		}
 	}
 }
@@ -0,0 +1,66 @@
+package rfc
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"regexp"
+	"strings"
+	"yapperbot-frs/src/wikitext"
+)
+
+// maxQuestionLength is the longest RfC question we'll send out, in characters;
+// anything longer is cut off at a word boundary and given an ellipsis.
+const maxQuestionLength int = 300
+
+// signatureLinkRegex matches the links that start a user's signature.
+var signatureLinkRegex *regexp.Regexp
+
+// timestampRegex matches the signature timestamp left at the end of an RfC statement,
+// once the (UTC) has been cut off.
+var timestampRegex *regexp.Regexp
+
+func init() {
+	signatureLinkRegex = regexp.MustCompile(`(?i)\[\[\s*(?:User(?:[ _]talk)?\s*:|Special\s*:\s*Contributions/)`)
+	timestampRegex = regexp.MustCompile(`\d{1,2}:\d{2}, \d{1,2} \w+ \d{4}\s*$`)
+}
+
+// QuestionFromStatement takes the wikitext of an RfC statement, running from just after
+// the {{rfc}} template up to (but not including) the (UTC) of the first signature,
+// and returns a cleaned-up, length-limited plain text version of the question.
+func QuestionFromStatement(statement string) string {
+	// the signature is on the last line of the statement, so the first user link
+	// on that line is where the signature starts
+	lastLineStart := strings.LastIndex(statement, "\n") + 1
+	if loc := signatureLinkRegex.FindStringIndex(statement[lastLineStart:]); loc != nil {
+		statement = statement[:lastLineStart+loc[0]]
+	}
+	statement = timestampRegex.ReplaceAllString(statement, "")
+
+	question := strings.TrimRight(wikitext.PlainText(statement), " -—–")
+
+	questionRunes := []rune(question)
+	if len(questionRunes) <= maxQuestionLength {
+		return question
+	}
+	question = string(questionRunes[:maxQuestionLength])
+	if lastSpace := strings.LastIndex(question, " "); lastSpace > 0 {
+		question = question[:lastSpace]
+	}
+	return question + "…"
+}
@@ -29,11 +29,20 @@ const requestType string = "request for comment"
 // An RfC has an id, a categories map and a setting for whether feedback has been given for it.
 // The map should be map[string]bool, with bool as true for every element
 // This is so membership verification is o(1) rather than o(n)
+// Question is a plain text summary of the RfC statement, from QuestionFromStatement.
 type RfC struct {
 	ID           string
 	Categories   map[string]bool
 	FeedbackDone bool
 	PageHolding  string
+	Question     string
+}
+
+// knownCategories are the RfC categories that can be given to {{rfc}}.
+var knownCategories = map[string]bool{
+	"bio": true, "econ": true, "hist": true, "lang": true, "sci": true, "media": true, "pol": true,
+	"reli": true, "soc": true, "style": true, "policy": true, "proj": true, "tech": true, "prop": true,
+	"unsorted": true,
 }
 
 func init() {
@@ -60,6 +69,13 @@ func (r RfC) IncludeHeader(header string) (bool, bool) {
 	return exists, false
 }
 
+// KnownHeader returns whether a FRS header is for RfCs with a category that exists,
+// or for all RfCs.
+func KnownHeader(header string) bool {
+	matches := rfcPrefixRegex.FindStringSubmatch(header)
+	return matches != nil && (matches[1] == "all" || knownCategories[matches[1]])
+}
+
 // PageTitle is a simple getter for the HoldingPage in order to make the interface work
 func (r RfC) PageTitle() string {
 	return r.PageHolding
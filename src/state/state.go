@@ -0,0 +1,253 @@
+package state
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"yapperbot-frs/src/dryrun"
+
+	"github.com/mashedkeyboard/ybtools/v2"
+	"github.com/metal3d/go-slugify"
+)
+
+// stateFilename is the file in the working directory the local state is kept in.
+const stateFilename string = "frs-state.json"
+
+// currentVersion is the version of the state file format we write. Loading a state file
+// with a newer version fails, rather than risking throwing away data we don't understand.
+const currentVersion int = 1
+
+// legacyRunfileSuffix is the suffix of the old per-category runfiles, which the
+// state store replaces. They're imported the first time the store is loaded.
+const legacyRunfileSuffix string = ".frsrunfile"
+
+// cursorsKey is the key in the state data that the category cursors are kept under.
+const cursorsKey string = "cursors"
+
+// stateFile is the on-disk format of the state store. Checksum is the hex SHA-256 of
+// Data as written, so that a file that's been damaged or hand-edited gets spotted.
+type stateFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// A Cursor records our progress through a category: the categorisation timestamp
+// and page ID of the latest page we've processed.
+type Cursor struct {
+	Timestamp string `json:"timestamp"`
+	PageID    string `json:"pageid"`
+}
+
+// data maps each key to the JSON of whatever local state is stored under it.
+var data map[string]json.RawMessage = map[string]json.RawMessage{}
+
+// dataMux guards data.
+var dataMux sync.Mutex
+
+// Load loads the state store from disk. If there isn't a state file yet, any old
+// runfiles are imported into it instead. It must be called before anything else here.
+func Load() {
+	dataMux.Lock()
+	defer dataMux.Unlock()
+
+	contents, err := ioutil.ReadFile(stateFilename)
+	if os.IsNotExist(err) {
+		migrateRunfiles()
+		return
+	} else if err != nil {
+		ybtools.PanicErr("Failed to read state file with error ", err)
+	}
+
+	var file stateFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		ybtools.PanicErr("State file ", stateFilename, " is corrupt, failed to parse with error ", err)
+	}
+	if file.Version > currentVersion {
+		ybtools.PanicErr("State file ", stateFilename, " has version ", file.Version, ", which is newer than this version of the bot understands")
+	}
+	if checksum(file.Data) != file.Checksum {
+		ybtools.PanicErr("State file ", stateFilename, " is corrupt, its checksum doesn't match")
+	}
+	if err := json.Unmarshal(file.Data, &data); err != nil {
+		ybtools.PanicErr("State file ", stateFilename, " is corrupt, failed to parse data with error ", err)
+	}
+}
+
+// Get takes a key and a pointer to a value, and loads the state stored under the key into the value.
+// It returns false if nothing has been stored under the key.
+func Get(key string, v interface{}) bool {
+	dataMux.Lock()
+	defer dataMux.Unlock()
+
+	raw, ok := data[key]
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		ybtools.PanicErr("Failed to load state for ", key, " with error ", err)
+	}
+	return true
+}
+
+// Set takes a key and a value, and stores the value under the key. It isn't written
+// to disk until Save is called.
+func Set(key string, v interface{}) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		ybtools.PanicErr("Failed to serialize state for ", key, " with error ", err)
+	}
+
+	dataMux.Lock()
+	defer dataMux.Unlock()
+	data[key] = raw
+}
+
+// GetCursor takes the name of a category, and returns our cursor for it, if we have one.
+func GetCursor(category string) (cursor Cursor, ok bool) {
+	var cursors map[string]Cursor
+	Get(cursorsKey, &cursors)
+	cursor, ok = cursors[cursorKey(category)]
+	return
+}
+
+// SetCursor takes the name of a category and a cursor, and stores the cursor for the category.
+func SetCursor(category string, cursor Cursor) {
+	var cursors = map[string]Cursor{}
+	Get(cursorsKey, &cursors)
+	cursors[cursorKey(category)] = cursor
+	Set(cursorsKey, cursors)
+}
+
+// Save writes the state store to disk. The file is written to a temporary file first,
+// and then moved into place, so a crash while saving leaves the old state intact.
+func Save() {
+	dataMux.Lock()
+	defer dataMux.Unlock()
+
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		ybtools.PanicErr("Failed to serialize state with error ", err)
+	}
+	contents, err := json.Marshal(stateFile{Version: currentVersion, Checksum: checksum(rawData), Data: rawData})
+	if err != nil {
+		ybtools.PanicErr("Failed to serialize state file with error ", err)
+	}
+
+	if dryrun.Enabled() {
+		// a dry run mustn't change our local state, or the next real run would skip things
+		dryrun.RecordLocalWrite(stateFilename, string(contents))
+		return
+	}
+
+	if err := writeFileAtomically(stateFilename, contents); err != nil {
+		ybtools.PanicErr("Failed to write state file with error ", err)
+	}
+}
+
+// cursorKey turns a category name into the key its cursor is stored under. This is the
+// same slug the old runfiles were named with, so that they can be imported.
+func cursorKey(category string) string {
+	return slugify.Marshal(category)
+}
+
+// migrateRunfiles imports the cursors from any old runfiles in the working directory.
+// The runfiles themselves are left alone; once the state file exists, they're ignored.
+// dataMux must be held.
+func migrateRunfiles() {
+	runfiles, err := filepath.Glob("*" + legacyRunfileSuffix)
+	if err != nil {
+		ybtools.PanicErr("Failed to look for runfiles to migrate with error ", err)
+	}
+
+	cursors := map[string]Cursor{}
+	for _, runfile := range runfiles {
+		contents, err := ioutil.ReadFile(runfile)
+		if err != nil {
+			ybtools.PanicErr("Failed to read runfile ", runfile, " to migrate it with error ", err)
+		}
+		if len(contents) == 0 {
+			// an empty runfile is the same as not having one at all
+			continue
+		}
+		// runfiles are in the form timestamp;pageid, with the page ID optional
+		splitRunfile := strings.SplitN(string(contents), ";", 2)
+		cursor := Cursor{Timestamp: splitRunfile[0]}
+		if len(splitRunfile) == 2 {
+			cursor.PageID = splitRunfile[1]
+		}
+		cursors[strings.TrimSuffix(runfile, legacyRunfileSuffix)] = cursor
+		log.Println("Migrated runfile", runfile, "into the state store; it can be deleted once the state file has been saved")
+	}
+
+	if len(cursors) > 0 {
+		raw, err := json.Marshal(cursors)
+		if err != nil {
+			ybtools.PanicErr("Failed to serialize migrated runfiles with error ", err)
+		}
+		data[cursorsKey] = raw
+	}
+}
+
+// checksum returns the hex SHA-256 of some JSON, compacted first so that
+// whitespace changes don't count.
+func checksum(rawJSON []byte) string {
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, rawJSON); err != nil {
+		// if it can't be compacted, it can't be valid; hash it as it is, so it fails to match
+		compacted.Reset()
+		compacted.Write(rawJSON)
+	}
+	sum := sha256.Sum256(compacted.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomically writes contents to a temporary file in the same directory as filename,
+// syncs it to disk, and then renames it over filename.
+func writeFileAtomically(filename string, contents []byte) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// if anything goes wrong, don't leave the temporary file lying around;
+	// once it's been renamed, this does nothing
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(contents); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), filename)
+}
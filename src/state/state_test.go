@@ -0,0 +1,191 @@
+//go:build fakewiki
+// +build fakewiki
+
+package state
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// These are behind the fakewiki build tag because this package needs the ybtools config files
+// to load, so they're run by the e2e package, which provides them.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"yapperbot-frs/src/dryrun"
+)
+
+// inTempDir moves into a new temporary directory for the rest of the test, as the state file
+// is kept in the working directory, and starts the store off empty.
+func inTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "frs-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(oldDir)
+		os.RemoveAll(dir)
+	})
+	data = map[string]json.RawMessage{}
+	return dir
+}
+
+// writeStateFile writes a state file with the given version, checksum and data.
+func writeStateFile(t *testing.T, version int, checksum, rawData string) {
+	contents := fmt.Sprintf(`{"version":%d,"checksum":%q,"data":%s}`, version, checksum, rawData)
+	if err := ioutil.WriteFile(stateFilename, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadPanic calls Load, and returns what it panicked with, or nil if it didn't.
+func loadPanic() (recovered interface{}) {
+	defer func() { recovered = recover() }()
+	Load()
+	return
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := inTempDir(t)
+	Set("example", []string{"one", "two"})
+	SetCursor("Category:Good article nominees", Cursor{Timestamp: "2020-06-01T12:00:00Z", PageID: "2002"})
+	Save()
+
+	// saving again replaces the file, rather than writing alongside it
+	Set("example", []string{"three"})
+	Save()
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 || filepath.Base(files[0]) != stateFilename {
+		t.Errorf("got files %q after saving, want just the state file", files)
+	}
+
+	data = map[string]json.RawMessage{}
+	Load()
+	var example []string
+	if !Get("example", &example) || len(example) != 1 || example[0] != "three" {
+		t.Errorf("got %q, want what was last saved", example)
+	}
+	if cursor, ok := GetCursor("Category:Good article nominees"); !ok || cursor.PageID != "2002" {
+		t.Errorf("got cursor %+v, want the one saved", cursor)
+	}
+}
+
+func TestLoadRejectsChecksumMismatch(t *testing.T) {
+	inTempDir(t)
+	rawData := `{"example":["one"]}`
+	writeStateFile(t, currentVersion, checksum([]byte(rawData)), `{"example":["two"]}`)
+
+	if recovered := loadPanic(); recovered == nil || !strings.Contains(fmt.Sprint(recovered), "checksum doesn't match") {
+		t.Errorf("got %v, want Load to fail on the checksum", recovered)
+	}
+}
+
+func TestLoadIgnoresWhitespaceInChecksum(t *testing.T) {
+	inTempDir(t)
+	writeStateFile(t, currentVersion, checksum([]byte(`{"example":["one"]}`)), `{ "example": [ "one" ] }`)
+
+	if recovered := loadPanic(); recovered != nil {
+		t.Errorf("Load failed with %v, want reformatting to leave the checksum matching", recovered)
+	}
+}
+
+func TestLoadRejectsNewerVersion(t *testing.T) {
+	inTempDir(t)
+	rawData := `{}`
+	writeStateFile(t, currentVersion+1, checksum([]byte(rawData)), rawData)
+
+	if recovered := loadPanic(); recovered == nil || !strings.Contains(fmt.Sprint(recovered), "newer than this version") {
+		t.Errorf("got %v, want Load to refuse the newer version", recovered)
+	}
+}
+
+func TestLoadMigratesRunfiles(t *testing.T) {
+	inTempDir(t)
+	runfiles := map[string]string{
+		"Category:Good article nominees":     "2020-06-01T12:00:00Z;2002",
+		"Category:Peer review":               "2020-05-01T12:00:00Z",
+		"Category:Featured article nominees": "",
+	}
+	for category, contents := range runfiles {
+		if err := ioutil.WriteFile(cursorKey(category)+legacyRunfileSuffix, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Load()
+
+	if cursor, ok := GetCursor("Category:Good article nominees"); !ok || cursor != (Cursor{Timestamp: "2020-06-01T12:00:00Z", PageID: "2002"}) {
+		t.Errorf("got cursor %+v for GA nominees, want the runfile's", cursor)
+	}
+	if cursor, ok := GetCursor("Category:Peer review"); !ok || cursor != (Cursor{Timestamp: "2020-05-01T12:00:00Z"}) {
+		t.Errorf("got cursor %+v for peer review, want the runfile's, with no page ID", cursor)
+	}
+	if cursor, ok := GetCursor("Category:Featured article nominees"); ok {
+		t.Errorf("got cursor %+v for an empty runfile, want none", cursor)
+	}
+
+	// once the state file's saved, the runfiles are ignored
+	Save()
+	if err := ioutil.WriteFile(cursorKey("Category:Peer review")+legacyRunfileSuffix, []byte("2020-06-10T12:00:00Z"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data = map[string]json.RawMessage{}
+	Load()
+	if cursor, _ := GetCursor("Category:Peer review"); cursor.Timestamp != "2020-05-01T12:00:00Z" {
+		t.Errorf("got cursor %+v for peer review, want the saved one, not the runfile's", cursor)
+	}
+}
+
+func TestWriteFileAtomicallyCleansUpOnFailure(t *testing.T) {
+	dir := inTempDir(t)
+	// renaming a file over a directory fails
+	if err := os.Mkdir(stateFilename, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomically(stateFilename, []byte("{}")); err == nil {
+		t.Error("writing over a directory succeeded, want it to fail")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(files) != 0 {
+		t.Errorf("got temporary files %q left behind, want them removed", files)
+	}
+}
+
+func TestSaveSkippedInDryRun(t *testing.T) {
+	dir := inTempDir(t)
+	dryrun.Enable(filepath.Join(dir, "dryrun"))
+	Set("example", []string{"one"})
+	Save()
+
+	if _, err := os.Stat(stateFilename); !os.IsNotExist(err) {
+		t.Errorf("the state file was written in a dry run, want it left alone")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "dryrun", "*-local-*")); len(files) != 1 {
+		t.Errorf("got dry run files %q, want the state file recorded as a local write", files)
+	}
+}
@@ -0,0 +1,352 @@
+package wiki
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"yapperbot-frs/src/yapperconfig"
+
+	"cgt.name/pkg/go-mwclient"
+	"cgt.name/pkg/go-mwclient/params"
+	"github.com/antonholmquist/jason"
+	"github.com/mashedkeyboard/ybtools/v2"
+)
+
+// Client is the real implementation of Wiki, talking to MediaWiki through mwclient.
+type Client struct {
+	w *mwclient.Client
+	// tokenMux guards fetching the CSRF token, as mwclient caches tokens in a map
+	// that isn't safe to write to while messages are being sent from several goroutines.
+	tokenMux sync.Mutex
+}
+
+// NewClient wraps an authenticated mwclient instance into a Client.
+func NewClient(w *mwclient.Client) *Client {
+	return &Client{w: w}
+}
+
+// EmbeddedIn returns every page transcluding the given template, in no particular order.
+func (c *Client) EmbeddedIn(template string) ([]Page, error) {
+	return c.queryPages(params.Values{
+		"action":    "query",
+		"prop":      "revisions",
+		"generator": "embeddedin",
+		"geititle":  template,
+		"rvprop":    "content",
+		"rvslots":   "main",
+	}, "")
+}
+
+// CategoryMembers returns the pages in a category that were categorised between
+// newest and oldest (both RFC3339 timestamps, inclusive), sorted from the most
+// recently categorised to the least.
+func (c *Client) CategoryMembers(category, newest, oldest string) ([]Page, error) {
+	pages, err := c.queryPages(params.Values{
+		"action":       "query",
+		"prop":         "revisions|categories",
+		"generator":    "categorymembers",
+		"gcmtitle":     category,
+		"gcmsort":      "timestamp",
+		"rvprop":       "content",
+		"rvslots":      "main",
+		"clprop":       "timestamp",
+		"clcategories": category,
+		"gcmdir":       "descending",
+		"gcmstart":     newest,
+		"gcmend":       oldest, // this is gcmend not gcmstart as it's going down from the most recent
+	}, category)
+	if err != nil {
+		return nil, err
+	}
+
+	// There seems to be no guarantee that the pages in the response will be ordered, in any way,
+	// so we have to sort them ourselves. RFC3339 timestamps in UTC sort correctly as strings.
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].CategorisedAt > pages[j].CategorisedAt
+	})
+	return pages, nil
+}
+
+// FetchWikitext gets the wikitext of the page with the given page ID.
+func (c *Client) FetchWikitext(pageID string) (string, error) {
+	return ybtools.FetchWikitext(pageID)
+}
+
+// FetchRevision gets the wikitext of the page with the given page ID, along with the timestamps
+// EditRevision needs.
+func (c *Client) FetchRevision(pageID string) (Revision, error) {
+	content, timestamp, startTimestamp, err := ybtools.FetchWikitextWithTimestamps(pageID)
+	if err != nil {
+		return Revision{}, err
+	}
+	return Revision{PageID: pageID, Content: content, Timestamp: timestamp, StartTimestamp: startTimestamp}, nil
+}
+
+// CanEdit returns whether we're allowed to make another edit at the moment.
+func (c *Client) CanEdit() bool {
+	return ybtools.CanEdit()
+}
+
+// EditPage replaces the text of the page with the given ID. This is only used for the
+// bot's own pages, which must be kept up to date under all circumstances - so we don't
+// wait for maxlag here.
+func (c *Client) EditPage(pageID, summary, text string) error {
+	return ybtools.NoMaxlagDo(func() error {
+		return c.w.Edit(params.Values{
+			"pageid":   pageID,
+			"summary":  summary,
+			"notminor": "true",
+			"bot":      "true",
+			"text":     text,
+		})
+	}, c.w)
+}
+
+// EditRevision replaces the text of a page fetched with FetchRevision. The basetimestamp and
+// starttimestamp params make MediaWiki reject the edit with an editconflict error if anyone has
+// edited the page since it was fetched, or with pagedeleted if it's been deleted. Like EditPage,
+// it doesn't wait for maxlag.
+func (c *Client) EditRevision(revision Revision, summary, text string) error {
+	return ybtools.NoMaxlagDo(func() error {
+		return c.w.Edit(params.Values{
+			"pageid":         revision.PageID,
+			"summary":        summary,
+			"notminor":       "true",
+			"bot":            "true",
+			"text":           text,
+			"basetimestamp":  revision.Timestamp,
+			"starttimestamp": revision.StartTimestamp,
+		})
+	}, c.w)
+}
+
+// NewSection adds a new section to the page with the given title. The redirect param
+// automatically resolves redirects, for instance if a user changes their username
+// but forgets to update the FRS user tag. It's safe to call from several goroutines
+// at once; pacing the edits is left to the caller.
+func (c *Client) NewSection(title, sectionTitle, summary, text string) error {
+	token, err := c.csrfToken()
+	if err != nil {
+		return err
+	}
+	return c.w.Edit(params.Values{
+		"title":        title,
+		"section":      "new",
+		"sectiontitle": sectionTitle,
+		"summary":      summary,
+		"notminor":     "true",
+		"bot":          "true",
+		"text":         text,
+		"redirect":     "true",
+		"token":        token,
+	})
+}
+
+// EmailUser sends an email to a user through the emailuser API.
+func (c *Client) EmailUser(username, subject, text string) error {
+	token, err := c.csrfToken()
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Post(params.Values{
+		"action":  "emailuser",
+		"target":  username,
+		"subject": subject,
+		"text":    text,
+		"token":   token,
+	})
+	return err
+}
+
+// csrfToken gets our CSRF token, fetching it if we don't have it yet.
+func (c *Client) csrfToken() (string, error) {
+	c.tokenMux.Lock()
+	defer c.tokenMux.Unlock()
+	return c.w.GetToken(mwclient.CSRFToken)
+}
+
+// usersPerQuery is the most users that list=users will take at once.
+const usersPerQuery int = 50
+
+// UserStatuses looks up the status of each of the given users. Blocks and whether the account exists
+// are fetched in batches through list=users, but global locks and latest edits can only be fetched
+// for one user at a time, so those take a query per user. Latest edits are only needed to exclude
+// inactive users, so they're not fetched at all unless inactivedays is configured.
+func (c *Client) UserStatuses(usernames []string) (map[string]UserStatus, error) {
+	statuses := map[string]UserStatus{}
+	for start := 0; start < len(usernames); start += usersPerQuery {
+		end := start + usersPerQuery
+		if end > len(usernames) {
+			end = len(usernames)
+		}
+		if err := c.fetchBlocks(usernames[start:end], statuses); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, username := range usernames {
+		status := statuses[username]
+		if status.Missing {
+			// there's nothing more to find out about an account that doesn't exist
+			continue
+		}
+
+		resp, err := c.w.Get(params.Values{
+			"action":  "query",
+			"meta":    "globaluserinfo",
+			"guiuser": username,
+		})
+		if err != nil {
+			return nil, err
+		}
+		// locked is only present at all if the account is locked
+		status.Locked, _ = resp.GetBoolean("query", "globaluserinfo", "locked")
+
+		if yapperconfig.Config.InactiveDays > 0 {
+			if status.LastEdit, err = c.fetchLastEdit(username); err != nil {
+				return nil, err
+			}
+		}
+
+		statuses[username] = status
+	}
+	return statuses, nil
+}
+
+// fetchLastEdit looks up the time of the user's latest edit, returning the zero time if they've never edited.
+func (c *Client) fetchLastEdit(username string) (time.Time, error) {
+	resp, err := c.w.Get(params.Values{
+		"action":  "query",
+		"list":    "usercontribs",
+		"ucuser":  username,
+		"uclimit": "1",
+		"ucprop":  "timestamp",
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	contribs, err := ybtools.GetThingFromQuery(resp, "usercontribs")
+	if err != nil || len(contribs) == 0 {
+		return time.Time{}, err
+	}
+	timestamp, err := contribs[0].GetString("timestamp")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, timestamp)
+}
+
+// fetchBlocks looks up whether each of the given users exists and is blocked sitewide,
+// storing the results into statuses.
+func (c *Client) fetchBlocks(usernames []string, statuses map[string]UserStatus) error {
+	resp, err := c.w.Get(params.Values{
+		"action":  "query",
+		"list":    "users",
+		"ususers": strings.Join(usernames, "|"),
+		"usprop":  "blockinfo",
+	})
+	if err != nil {
+		return err
+	}
+
+	// the names in the response are normalised, so map them back to the names we were given
+	givenNames := map[string]string{}
+	for _, username := range usernames {
+		givenNames[username] = username
+	}
+	if query, err := resp.GetObject("query"); err == nil {
+		if normalized, err := query.GetObjectArray("normalized"); err == nil {
+			for _, n := range normalized {
+				from, _ := n.GetString("from")
+				to, _ := n.GetString("to")
+				givenNames[to] = from
+			}
+		}
+	}
+
+	users, err := ybtools.GetThingFromQuery(resp, "users")
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		name, err := user.GetString("name")
+		if err != nil {
+			return err
+		}
+		var status UserStatus
+		status.Missing, _ = user.GetBoolean("missing")
+		if _, err := user.GetInt64("blockid"); err == nil {
+			// partial blocks don't stop anyone from taking part in most discussions
+			partial, _ := user.GetBoolean("blockpartial")
+			status.Blocked = !partial
+		}
+		statuses[givenNames[name]] = status
+	}
+	return nil
+}
+
+// queryPages runs a query with the given parameters, and turns every page in the
+// response into a Page. If category is set, it also fills in CategorisedAt.
+func (c *Client) queryPages(parameters params.Values, category string) (pages []Page, err error) {
+	query := c.w.NewQuery(parameters)
+	for query.Next() {
+		for index, page := range ybtools.GetPagesFromQuery(query.Resp()) {
+			if p, ok := pageFromJSON(page, index, category); ok {
+				pages = append(pages, p)
+			}
+		}
+	}
+	return pages, query.Err()
+}
+
+// pageFromJSON converts a page object from a query response into a Page,
+// returning false as the second value if the page should be skipped.
+func pageFromJSON(page *jason.Object, index int, category string) (Page, bool) {
+	pageIDInt, err := page.GetInt64("pageid")
+	if err != nil {
+		ybtools.PanicErr("Failed to get pageid from page with index ", index, ", error was: ", err)
+	}
+	// Remember to do this! Golang by default turns integers just into the
+	// corresponding unicode sequence with string(n) - e.g. string(5)
+	// returns "\x05"
+	pageID := strconv.FormatInt(pageIDInt, 10)
+
+	pageTitle, err := page.GetString("title")
+	if err != nil {
+		log.Println("Failed to get title from page ID", pageID, "so skipping it")
+		return Page{}, false
+	}
+
+	pageContent, err := ybtools.GetContentFromPage(page)
+	if err != nil {
+		log.Println("getContentFromPage failed on page ID", pageID, "so skipping it")
+		return Page{}, false
+	}
+
+	p := Page{ID: pageID, Title: pageTitle, Content: pageContent}
+	if category != "" {
+		p.CategorisedAt = ybtools.GetCategorisationTimestampFromPage(page, category)
+	}
+	return p, true
+}
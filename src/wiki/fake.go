@@ -0,0 +1,368 @@
+package wiki
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"yapperbot-frs/src/clock"
+
+	"cgt.name/pkg/go-mwclient"
+)
+
+// fixtureFilename is the name of the file describing the pages in a fixture directory.
+const fixtureFilename string = "pages.json"
+
+// A FakePage is a single page held by a FakeWiki.
+type FakePage struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Content is the wikitext of the page. In a fixture file, File can be used
+	// instead, naming a file in the fixture directory holding the wikitext.
+	Content string `json:"content"`
+	File    string `json:"file"`
+	// Categories maps each category the page is in to the timestamp it was categorised at.
+	Categories map[string]string `json:"categories"`
+	// Templates lists the templates the page transcludes, e.g. "Template:Rfc".
+	Templates []string `json:"templates"`
+	// Protected is true if the bot can't edit the page, so adding a section to it fails.
+	Protected bool `json:"protected"`
+
+	// edits counts the edits made to the page, so that EditRevision can tell if it's changed.
+	edits int
+}
+
+// A FakeUser is the status of a single user held by a FakeWiki.
+type FakeUser struct {
+	Missing bool `json:"missing"`
+	Blocked bool `json:"blocked"`
+	Locked  bool `json:"locked"`
+	// NoEmail is true if the user can't be emailed.
+	NoEmail bool `json:"noemail"`
+	// LastEdit is the RFC3339 timestamp of the user's latest edit, or empty if they've never edited.
+	LastEdit string `json:"lastedit"`
+}
+
+// A FakeEdit records a single edit made to a FakeWiki.
+type FakeEdit struct {
+	Title        string
+	PageID       string
+	SectionTitle string
+	Summary      string
+	Text         string
+}
+
+// A FakeEmail records a single email sent through a FakeWiki.
+type FakeEmail struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// FakeWiki is an in-memory implementation of Wiki, for running the FRS offline.
+// Edits made to it change the pages it holds, and are also recorded in order in Edits.
+type FakeWiki struct {
+	Edits []FakeEdit
+	// Emails records every email sent, in order.
+	Emails []FakeEmail
+	// Users maps usernames to their statuses. Users not in the map are treated
+	// as existing, unblocked, and having just edited.
+	Users map[string]FakeUser
+
+	pages   []*FakePage
+	pagesMu sync.Mutex
+	lastID  int
+}
+
+// NewFakeWiki creates an empty FakeWiki.
+func NewFakeWiki() *FakeWiki {
+	return &FakeWiki{Users: map[string]FakeUser{}}
+}
+
+// LoadFakeWiki creates a FakeWiki seeded from the fixture directory dir. The directory
+// must contain a pages.json holding {"pages": [...]}, with each entry being a FakePage.
+// It can also hold {"users": {"username": {...}}}, with each entry being a FakeUser.
+func LoadFakeWiki(dir string) (*FakeWiki, error) {
+	fixtureJSON, err := ioutil.ReadFile(filepath.Join(dir, fixtureFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	var fixture struct {
+		Pages []FakePage          `json:"pages"`
+		Users map[string]FakeUser `json:"users"`
+	}
+	if err := json.Unmarshal(fixtureJSON, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", fixtureFilename, err)
+	}
+
+	f := NewFakeWiki()
+	for username, user := range fixture.Users {
+		f.Users[username] = user
+	}
+	for _, page := range fixture.Pages {
+		if page.File != "" {
+			content, err := ioutil.ReadFile(filepath.Join(dir, page.File))
+			if err != nil {
+				return nil, err
+			}
+			page.Content = string(content)
+		}
+		f.AddPage(page)
+	}
+	return f, nil
+}
+
+// AddPage adds a page to the FakeWiki, and returns it. If the page has no ID, one is assigned.
+func (f *FakeWiki) AddPage(page FakePage) *FakePage {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+	return f.addPage(page)
+}
+
+// Page returns the page with the given title, or nil if there isn't one.
+func (f *FakeWiki) Page(title string) *FakePage {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+	return f.pageByTitle(title)
+}
+
+// EmbeddedIn returns every page listing the given template in its Templates.
+func (f *FakeWiki) EmbeddedIn(template string) (pages []Page, err error) {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	for _, page := range f.pages {
+		for _, t := range page.Templates {
+			if t == template {
+				pages = append(pages, Page{ID: page.ID, Title: page.Title, Content: page.Content})
+				break
+			}
+		}
+	}
+	return
+}
+
+// CategoryMembers returns the pages in a category categorised between newest and oldest,
+// sorted from the most recently categorised to the least.
+func (f *FakeWiki) CategoryMembers(category, newest, oldest string) (pages []Page, err error) {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	for _, page := range f.pages {
+		timestamp, ok := page.Categories[category]
+		if ok && timestamp <= newest && timestamp >= oldest {
+			pages = append(pages, Page{ID: page.ID, Title: page.Title, Content: page.Content, CategorisedAt: timestamp})
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].CategorisedAt > pages[j].CategorisedAt
+	})
+	return
+}
+
+// FetchWikitext gets the wikitext of the page with the given page ID.
+func (f *FakeWiki) FetchWikitext(pageID string) (string, error) {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	if page := f.pageByID(pageID); page != nil {
+		return page.Content, nil
+	}
+	return "", mwclient.ErrPageNotFound
+}
+
+// FetchRevision gets the wikitext of the page with the given page ID.
+func (f *FakeWiki) FetchRevision(pageID string) (Revision, error) {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	page := f.pageByID(pageID)
+	if page == nil {
+		return Revision{}, mwclient.ErrPageNotFound
+	}
+	now := clock.Now().UTC().Format(time.RFC3339)
+	return Revision{PageID: pageID, Content: page.Content, Timestamp: now, StartTimestamp: now, edits: page.edits}, nil
+}
+
+// CanEdit always returns true; the FakeWiki has no edit limit.
+func (f *FakeWiki) CanEdit() bool {
+	return true
+}
+
+// EditPage replaces the text of the page with the given ID.
+func (f *FakeWiki) EditPage(pageID, summary, text string) error {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	page := f.pageByID(pageID)
+	if page == nil {
+		return mwclient.APIError{Code: "nosuchpageid", Info: "There is no page with ID " + pageID + "."}
+	}
+	f.edit(page, summary, text)
+	return nil
+}
+
+// EditRevision replaces the text of a page fetched with FetchRevision, failing with
+// the editconflict error code if the page has been edited since.
+func (f *FakeWiki) EditRevision(revision Revision, summary, text string) error {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	page := f.pageByID(revision.PageID)
+	if page == nil {
+		return mwclient.APIError{Code: "pagedeleted", Info: "The page has been deleted since you fetched its timestamp."}
+	}
+	if page.edits != revision.edits {
+		return mwclient.APIError{Code: "editconflict", Info: "Edit conflict."}
+	}
+	f.edit(page, summary, text)
+	return nil
+}
+
+// edit replaces the text of a page, and records the edit. pagesMu must be held.
+func (f *FakeWiki) edit(page *FakePage, summary, text string) {
+	page.Content = text
+	page.edits++
+	f.Edits = append(f.Edits, FakeEdit{Title: page.Title, PageID: page.ID, Summary: summary, Text: text})
+	log.Println("FakeWiki: edited page ID", page.ID)
+}
+
+// NewSection adds a new section to the page with the given title, creating it if needed.
+func (f *FakeWiki) NewSection(title, sectionTitle, summary, text string) error {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	page := f.pageByTitle(title)
+	if page == nil {
+		page = f.addPage(FakePage{Title: title})
+	}
+	if page.Protected {
+		return mwclient.APIError{Code: "protectedpage", Info: "This page has been protected to prevent editing or other actions."}
+	}
+
+	var contentBuilder strings.Builder
+	contentBuilder.WriteString(page.Content)
+	if page.Content != "" {
+		contentBuilder.WriteString("\n\n")
+	}
+	contentBuilder.WriteString("== ")
+	contentBuilder.WriteString(sectionTitle)
+	contentBuilder.WriteString(" ==\n")
+	contentBuilder.WriteString(text)
+	page.Content = contentBuilder.String()
+	page.edits++
+
+	f.Edits = append(f.Edits, FakeEdit{Title: title, PageID: page.ID, SectionTitle: sectionTitle, Summary: summary, Text: text})
+	log.Println("FakeWiki: added section", sectionTitle, "to", title)
+	return nil
+}
+
+// Sections returns every section added to the page with the given title through NewSection.
+func (f *FakeWiki) Sections(title string) (sections []FakeEdit) {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	for _, edit := range f.Edits {
+		if edit.Title == title && edit.SectionTitle != "" {
+			sections = append(sections, edit)
+		}
+	}
+	return
+}
+
+// addPage adds a page, assigning an ID if it doesn't have one. pagesMu must be held.
+func (f *FakeWiki) addPage(page FakePage) *FakePage {
+	if page.ID == "" {
+		f.lastID++
+		page.ID = strconv.Itoa(f.lastID)
+	} else if id, err := strconv.Atoi(page.ID); err == nil && id > f.lastID {
+		f.lastID = id
+	}
+	f.pages = append(f.pages, &page)
+	return &page
+}
+
+// EmailUser records an email to a user in Emails, or fails with the noemail
+// error code if the user is set up with NoEmail.
+func (f *FakeWiki) EmailUser(username, subject, text string) error {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	if f.Users[username].NoEmail {
+		return mwclient.APIError{Code: "noemail", Info: "This user has not specified a valid email address."}
+	}
+	f.Emails = append(f.Emails, FakeEmail{To: username, Subject: subject, Text: text})
+	log.Println("FakeWiki: emailed", username)
+	return nil
+}
+
+// UserStatuses returns the status of each of the given users from Users.
+func (f *FakeWiki) UserStatuses(usernames []string) (map[string]UserStatus, error) {
+	f.pagesMu.Lock()
+	defer f.pagesMu.Unlock()
+
+	statuses := map[string]UserStatus{}
+	for _, username := range usernames {
+		user, ok := f.Users[username]
+		if !ok {
+			statuses[username] = UserStatus{LastEdit: clock.Now()}
+			continue
+		}
+
+		status := UserStatus{Missing: user.Missing, Blocked: user.Blocked, Locked: user.Locked}
+		if user.LastEdit != "" {
+			lastEdit, err := time.Parse(time.RFC3339, user.LastEdit)
+			if err != nil {
+				return nil, err
+			}
+			status.LastEdit = lastEdit
+		}
+		statuses[username] = status
+	}
+	return statuses, nil
+}
+
+// pageByID finds the page with the given ID. pagesMu must be held.
+func (f *FakeWiki) pageByID(pageID string) *FakePage {
+	for _, page := range f.pages {
+		if page.ID == pageID {
+			return page
+		}
+	}
+	return nil
+}
+
+// pageByTitle finds the page with the given title. pagesMu must be held.
+func (f *FakeWiki) pageByTitle(title string) *FakePage {
+	for _, page := range f.pages {
+		if page.Title == title {
+			return page
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package wiki
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"time"
+
+	"github.com/antonholmquist/jason"
+	"github.com/mashedkeyboard/ybtools/v2"
+)
+
+// A Page is a single page returned from the wiki, along with the content of its latest revision.
+type Page struct {
+	ID      string
+	Title   string
+	Content string
+	// CategorisedAt is the timestamp at which the page was added to the category
+	// it was queried from. It's only set on pages returned from CategoryMembers.
+	CategorisedAt string
+}
+
+// A Revision is the wikitext of a page as it was fetched to be edited, along with what's needed
+// to save changes to it with EditRevision without overwriting anyone else's edits in between.
+type Revision struct {
+	PageID  string
+	Content string
+	// Timestamp is when the fetched revision was made, and StartTimestamp is when it was fetched.
+	Timestamp      string
+	StartTimestamp string
+
+	// edits is the number of edits a FakeWiki had made to the page when it was fetched; edits to
+	// a FakeWiki can be less than a second apart, so it can't rely on the timestamps.
+	edits int
+}
+
+// A UserStatus is what the wiki knows about a user account that affects whether we should message them.
+type UserStatus struct {
+	// Missing is true if there's no account with the username.
+	Missing bool
+	// Blocked is true if the user is blocked from the whole of the local wiki.
+	Blocked bool
+	// Locked is true if the user's global account is locked.
+	Locked bool
+	// LastEdit is the time of the user's latest edit, or the zero time if they've never edited.
+	// It's only looked up if inactivedays is configured, as nothing else needs it.
+	LastEdit time.Time
+}
+
+// Wiki is the interface covering everything the FRS needs to do on the wiki.
+// Every package that reads from or writes to the wiki should go through this,
+// rather than using mwclient or the ybtools fetchers directly, so that the whole
+// run can be done against a FakeWiki.
+type Wiki interface {
+	// EmbeddedIn returns every page transcluding the given template, in no particular order.
+	EmbeddedIn(template string) ([]Page, error)
+
+	// CategoryMembers returns the pages in a category that were categorised between
+	// newest and oldest (both RFC3339 timestamps, inclusive), sorted from the most
+	// recently categorised to the least.
+	CategoryMembers(category, newest, oldest string) ([]Page, error)
+
+	// FetchWikitext gets the wikitext of the page with the given page ID.
+	FetchWikitext(pageID string) (string, error)
+
+	// FetchRevision gets the wikitext of the page with the given page ID, so that it can be changed
+	// and saved back with EditRevision.
+	FetchRevision(pageID string) (Revision, error)
+
+	// CanEdit returns whether we're allowed to make another edit at the moment,
+	// taking into account the edit limit and the task kill page.
+	CanEdit() bool
+
+	// EditPage replaces the text of the page with the given ID. It's used for the
+	// pages the bot maintains itself, so it should work regardless of maxlag.
+	EditPage(pageID, summary, text string) error
+
+	// EditRevision replaces the text of a page fetched with FetchRevision. If the page has been
+	// edited since it was fetched, it fails with an APIError with the code editconflict, rather than
+	// overwriting the other edit. It's used for pages that people edit as well as the bot.
+	EditRevision(revision Revision, summary, text string) error
+
+	// NewSection adds a new section to the page with the given title, resolving redirects.
+	NewSection(title, sectionTitle, summary, text string) error
+
+	// EmailUser sends an email to a user through Special:EmailUser. It fails with an APIError
+	// if the user can't be emailed, for instance because they haven't enabled email.
+	EmailUser(username, subject, text string) error
+
+	// UserStatuses looks up the status of each of the given users, returning them mapped
+	// from the usernames as given.
+	UserStatuses(usernames []string) (map[string]UserStatus, error)
+}
+
+// LoadJSONFromPageID takes a Wiki and a pageID, then loads and deserializes the contained JSON.
+// It returns the deserialised JSON in a jason.Object pointer.
+func LoadJSONFromPageID(w Wiki, pageID string) *jason.Object {
+	storedJSON, err := w.FetchWikitext(pageID)
+	if err != nil {
+		ybtools.PanicErr("Failed to fetch JSON page with ID ", pageID, " with error ", err)
+	}
+	parsedJSON, err := jason.NewObjectFromBytes([]byte(storedJSON))
+	if err != nil {
+		ybtools.PanicErr("Failed to parse JSON on page ID ", pageID, " with error ", err)
+	}
+	return parsedJSON
+}
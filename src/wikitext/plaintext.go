@@ -0,0 +1,92 @@
+package wikitext
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"regexp"
+	"strings"
+)
+
+// refRegex matches <ref> tags and their contents, including self-closing named refs.
+var refRegex *regexp.Regexp
+
+// htmlTagRegex matches any remaining HTML tags, opening or closing.
+var htmlTagRegex *regexp.Regexp
+
+// fileLinkRegex matches links to files and categories, which don't have any readable text.
+var fileLinkRegex *regexp.Regexp
+
+// internalLinkRegex matches internal links; the first capture group is the text that's displayed.
+var internalLinkRegex *regexp.Regexp
+
+// externalLinkRegex matches bracketed external links; the first capture group is the label, if any.
+var externalLinkRegex *regexp.Regexp
+
+// emphasisRegex matches the apostrophes used for bold and italics.
+var emphasisRegex *regexp.Regexp
+
+func init() {
+	refRegex = regexp.MustCompile(`(?is)<ref[^>]*?/>|<ref[^>]*>.*?</ref>`)
+	htmlTagRegex = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	fileLinkRegex = regexp.MustCompile(`(?i)\[\[\s*:?\s*(?:File|Image|Category)\s*:[^\]]*\]\]`)
+	internalLinkRegex = regexp.MustCompile(`\[\[(?:[^|\]]*\|)?([^\]]*)\]\]`)
+	externalLinkRegex = regexp.MustCompile(`\[(?:https?:)?//[^\s\]]*\s*([^\]]*)\]`)
+	emphasisRegex = regexp.MustCompile(`'{2,}`)
+}
+
+// PlainText takes some wikitext, and strips the markup out of it, leaving just the
+// readable text with whitespace collapsed. Templates, comments, references and
+// file links are removed entirely; links are replaced with the text they display.
+func PlainText(text string) string {
+	text = removeTemplates(text)
+	text = refRegex.ReplaceAllString(text, "")
+	text = htmlTagRegex.ReplaceAllString(text, "")
+	text = fileLinkRegex.ReplaceAllString(text, "")
+	text = internalLinkRegex.ReplaceAllString(text, "$1")
+	text = externalLinkRegex.ReplaceAllString(text, "$1")
+	text = emphasisRegex.ReplaceAllString(text, "")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// removeTemplates takes some wikitext, and returns it with every comment and
+// top-level template removed (which takes any templates nested in them with them).
+func removeTemplates(text string) string {
+	var b strings.Builder
+	var last int
+	for _, template := range ParseTemplates(text) {
+		b.WriteString(stripComments(text[last:template.Start]))
+		last = template.End
+	}
+	b.WriteString(stripComments(text[last:]))
+	return b.String()
+}
+
+// stripComments takes some wikitext, and returns it with any HTML comments removed.
+func stripComments(text string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, commentOpen)
+		if start == -1 {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(text[:start])
+		text = text[skipComment(text, start):]
+	}
+}
@@ -0,0 +1,218 @@
+package wikitext
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"strings"
+)
+
+// A Template is a single template transclusion found in some wikitext.
+type Template struct {
+	// Name is the name of the template, as written, minus surrounding whitespace.
+	Name string
+	// Params holds every parameter as written, in order, without the separating pipes.
+	Params []string
+	// Positional holds the unnamed parameters, in order, minus surrounding whitespace.
+	Positional []string
+	// Named maps each named parameter's name to its value, both minus surrounding whitespace.
+	Named map[string]string
+	// Start and End are the byte offsets of the opening {{ and just after the closing }}.
+	Start, End int
+}
+
+// A token pairs up an opening piece of markup with its closing counterpart.
+type token struct {
+	open, close string
+}
+
+// nestingTokens are the bits of markup that can contain pipes and equals signs which
+// don't belong to the template we're parsing. Longer tokens come first, so that
+// {{{parameters}}} aren't mistaken for templates.
+var nestingTokens = []token{{"{{{", "}}}"}, {"{{", "}}"}, {"[[", "]]"}}
+
+const commentOpen string = "<!--"
+const commentClose string = "-->"
+
+// ParseTemplates takes some wikitext, and returns every template in it, in the order they start.
+// That includes templates nested inside the parameters of other templates, such as banners inside
+// {{WikiProject banner shell}}, which come straight after the template they're in; they're also
+// kept intact, braces and all, in the parameter they're in. HTML comments are skipped entirely,
+// and unclosed templates are ignored.
+func ParseTemplates(text string) []Template {
+	return parseTemplatesBetween(text, 0, len(text))
+}
+
+// parseTemplatesBetween takes some wikitext and a range of offsets within it, and returns every
+// template in that range, recursing into each template's parameters. Offsets are always into
+// the whole of the text, so that they're valid wherever the template was found.
+func parseTemplatesBetween(text string, from, to int) (templates []Template) {
+	for i := from; i < to; {
+		switch {
+		case strings.HasPrefix(text[i:to], commentOpen):
+			i = skipComment(text[:to], i)
+		case strings.HasPrefix(text[i:to], "{{{"):
+			// a template parameter, not a template; just step over the braces
+			i += 3
+		case strings.HasPrefix(text[i:to], "{{"):
+			if template, ok := parseTemplate(text[:to], i); ok {
+				templates = append(templates, template)
+				// everything between the opening and closing braces may hold more templates
+				templates = append(templates, parseTemplatesBetween(text, template.Start+2, template.End-2)...)
+				i = template.End
+			} else {
+				i += 2
+			}
+		default:
+			i++
+		}
+	}
+	return
+}
+
+// Is takes a template name, and returns whether this template is that template.
+// Like MediaWiki, it ignores the case of the first letter and treats underscores
+// as spaces; unlike MediaWiki, it ignores case entirely, as redirects like {{RfC}}
+// are common. It also ignores any Template: namespace or subst: prefix.
+func (t Template) Is(name string) bool {
+	return strings.EqualFold(normaliseName(t.Name), normaliseName(name))
+}
+
+// Param takes a parameter name, and returns the value of the named parameter and
+// whether it was set at all.
+func (t Template) Param(name string) (value string, ok bool) {
+	value, ok = t.Named[name]
+	return
+}
+
+// parseTemplate takes some wikitext and the offset of the {{ starting a template,
+// and parses the template. If the template is never closed, it returns false.
+func parseTemplate(text string, start int) (t Template, ok bool) {
+	var parts []string
+	var current strings.Builder
+	// stack holds the closing tokens for everything currently open inside the template
+	var stack []string
+
+	t = Template{Start: start, Named: map[string]string{}}
+
+	i := start + 2
+SCANLOOP:
+	for i < len(text) {
+		if strings.HasPrefix(text[i:], commentOpen) {
+			i = skipComment(text, i)
+			continue SCANLOOP
+		}
+
+		if len(stack) > 0 && strings.HasPrefix(text[i:], stack[len(stack)-1]) {
+			closing := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			current.WriteString(closing)
+			i += len(closing)
+			continue SCANLOOP
+		}
+
+		if len(stack) == 0 && strings.HasPrefix(text[i:], "}}") {
+			parts = append(parts, current.String())
+			t.End = i + 2
+			ok = true
+			break SCANLOOP
+		}
+
+		for _, tok := range nestingTokens {
+			if strings.HasPrefix(text[i:], tok.open) {
+				stack = append(stack, tok.close)
+				current.WriteString(tok.open)
+				i += len(tok.open)
+				continue SCANLOOP
+			}
+		}
+
+		if len(stack) == 0 && text[i] == '|' {
+			parts = append(parts, current.String())
+			current.Reset()
+		} else {
+			current.WriteByte(text[i])
+		}
+		i++
+	}
+
+	if !ok {
+		return Template{}, false
+	}
+
+	t.Name = strings.TrimSpace(parts[0])
+	t.Params = parts[1:]
+	for _, param := range t.Params {
+		if name, value, named := splitNamedParam(param); named {
+			t.Named[name] = value
+		} else {
+			t.Positional = append(t.Positional, strings.TrimSpace(param))
+		}
+	}
+	return t, true
+}
+
+// splitNamedParam takes a single parameter, and if it's a named parameter, returns its
+// name and value. Only an equals sign outside of any nested markup counts, so that
+// {{=}} and the like in an unnamed parameter don't make it named.
+func splitNamedParam(param string) (name, value string, named bool) {
+	var stack []string
+	i := 0
+PARAMLOOP:
+	for i < len(param) {
+		if len(stack) > 0 && strings.HasPrefix(param[i:], stack[len(stack)-1]) {
+			i += len(stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+			continue PARAMLOOP
+		}
+		for _, tok := range nestingTokens {
+			if strings.HasPrefix(param[i:], tok.open) {
+				stack = append(stack, tok.close)
+				i += len(tok.open)
+				continue PARAMLOOP
+			}
+		}
+		if len(stack) == 0 && param[i] == '=' {
+			return strings.TrimSpace(param[:i]), strings.TrimSpace(param[i+1:]), true
+		}
+		i++
+	}
+	return "", "", false
+}
+
+// skipComment takes some wikitext and the offset of the start of an HTML comment,
+// and returns the offset just after the end of it. Unclosed comments run to the end.
+func skipComment(text string, start int) int {
+	end := strings.Index(text[start+len(commentOpen):], commentClose)
+	if end == -1 {
+		return len(text)
+	}
+	return start + len(commentOpen) + end + len(commentClose)
+}
+
+// normaliseName takes a template name, and strips any subst: or Template: prefix,
+// turns underscores into spaces, and collapses runs of whitespace.
+func normaliseName(name string) string {
+	name = strings.TrimSpace(name)
+	for _, prefix := range []string{"safesubst:", "subst:", "template:"} {
+		if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			name = strings.TrimSpace(name[len(prefix):])
+		}
+	}
+	return strings.Join(strings.Fields(strings.ReplaceAll(name, "_", " ")), " ")
+}
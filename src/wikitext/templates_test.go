@@ -0,0 +1,129 @@
+package wikitext
+
+//
+// Yapperbot-FRS, the Feedback Request Service bot for Wikipedia
+// Copyright (C) 2020 Naypta
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTemplates(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		// want holds the name, positional and named parameters of each template expected, in order
+		want []Template
+	}{
+		{
+			name: "simple",
+			text: "before {{rfc|bio|rfcid=ABC}} after",
+			want: []Template{{Name: "rfc", Positional: []string{"bio"}, Named: map[string]string{"rfcid": "ABC"}}},
+		},
+		{
+			name: "nested braces",
+			text: "{{rfc|bio|rfcid={{subst:x}}}}",
+			want: []Template{
+				{Name: "rfc", Positional: []string{"bio"}, Named: map[string]string{"rfcid": "{{subst:x}}"}},
+				{Name: "subst:x", Named: map[string]string{}},
+			},
+		},
+		{
+			name: "whitespace",
+			text: "{{ rfc | bio | rfcid = abc }}",
+			want: []Template{{Name: "rfc", Positional: []string{"bio"}, Named: map[string]string{"rfcid": "abc"}}},
+		},
+		{
+			name: "multi-line params",
+			text: "{{GA nominee\n|topic=Geography\n|subtopic=\n  Places\n}}",
+			want: []Template{{Name: "GA nominee", Named: map[string]string{"topic": "Geography", "subtopic": "Places"}}},
+		},
+		{
+			name: "unterminated",
+			text: "{{rfc|bio|rfcid=ABC\n\nno closing braces {{GA nominee|topic=Geography}}",
+			want: []Template{{Name: "GA nominee", Named: map[string]string{"topic": "Geography"}}},
+		},
+		{
+			name: "comments",
+			text: "<!-- {{rfc|hidden}} -->{{rfc|bio<!-- |rfcid=hidden -->|rfcid=ABC}}",
+			want: []Template{{Name: "rfc", Positional: []string{"bio"}, Named: map[string]string{"rfcid": "ABC"}}},
+		},
+		{
+			name: "template parameters",
+			text: "{{{param|default}}} {{rfc|{{{1}}}|rfcid={{{id|ABC}}}}}",
+			want: []Template{{Name: "rfc", Positional: []string{"{{{1}}}"}, Named: map[string]string{"rfcid": "{{{id|ABC}}}"}}},
+		},
+		{
+			name: "links",
+			text: "{{rfc|bio|question=Is [[Foo|bar]] right?}}",
+			want: []Template{{Name: "rfc", Positional: []string{"bio"}, Named: map[string]string{"question": "Is [[Foo|bar]] right?"}}},
+		},
+		{
+			name: "banner shell",
+			text: "{{WikiProject banner shell|1=\n{{GA nominee|1=~~~~~|subtopic=Music}}\n{{WikiProject Music}}\n}}",
+			want: []Template{
+				{Name: "WikiProject banner shell", Named: map[string]string{"1": "{{GA nominee|1=~~~~~|subtopic=Music}}\n{{WikiProject Music}}"}},
+				{Name: "GA nominee", Named: map[string]string{"1": "~~~~~", "subtopic": "Music"}},
+				{Name: "WikiProject Music", Named: map[string]string{}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ParseTemplates(test.text)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d templates, want %d: %+v", len(got), len(test.want), got)
+			}
+			for i, want := range test.want {
+				if got[i].Name != want.Name {
+					t.Errorf("template %d: got name %q, want %q", i, got[i].Name, want.Name)
+				}
+				if !reflect.DeepEqual(got[i].Positional, want.Positional) {
+					t.Errorf("template %d: got positional %q, want %q", i, got[i].Positional, want.Positional)
+				}
+				if !reflect.DeepEqual(got[i].Named, want.Named) {
+					t.Errorf("template %d: got named %q, want %q", i, got[i].Named, want.Named)
+				}
+				if test.text[got[i].Start:got[i].Start+2] != "{{" || test.text[got[i].End-2:got[i].End] != "}}" {
+					t.Errorf("template %d: offsets %d-%d don't cover the template", i, got[i].Start, got[i].End)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateIs(t *testing.T) {
+	tests := []struct {
+		written, name string
+		want          bool
+	}{
+		{"rfc", "rfc", true},
+		{"RfC", "rfc", true},
+		{"Template:Rfc", "rfc", true},
+		{"subst:GA_nominee", "GA nominee", true},
+		{"Requested  move/dated", "Requested move/dated", true},
+		{"rfc top", "rfc", false},
+	}
+
+	for _, test := range tests {
+		if got := (Template{Name: test.written}).Is(test.name); got != test.want {
+			t.Errorf("Template{Name: %q}.Is(%q) = %v, want %v", test.written, test.name, got, test.want)
+		}
+	}
+}
@@ -27,6 +27,23 @@ type configObject struct {
 	SentCountPageID          string
 	GAGuidelinesHeaderPageID string
 	RFCsDonePageID           string
+	RMsDonePageID            string
+	RunReportPath            string
+	LintReportPageID         string
+	LintAutoFix              bool
+	InactiveDays             int
+	RollingWindowDays        int
+	SentCountHistoryMonths   int
+	ArticlePath              string
+	MessageTemplatesPageID   string
+	RetryMaxAttempts         int
+	SendRate                 float64
+	SendBurst                int
+	SendWorkers              int
+	// SelectionStrategies maps FRS headers and request types to the names of the
+	// selection strategies used for them; DefaultSelectionStrategy is used otherwise.
+	SelectionStrategies      map[string]string
+	DefaultSelectionStrategy string
 }
 
 // Config is the global configuration object. This should only really ever be read from.